@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -22,6 +23,15 @@ func main() {
 		log.Fatalf("parse flags: %v", err)
 	}
 
+	if flags.GenerateCert.Value() {
+		certPath, keyPath, err := config.RunGenerateCert(flags)
+		if err != nil {
+			log.Fatalf("generate cert: %v", err)
+		}
+		fmt.Printf("wrote self-signed certificate to %s and key to %s\n", certPath, keyPath)
+		return
+	}
+
 	ov := config.OverridesFromFlags(flags)
 	configPath := ""
 	if ov.ConfigPath != nil {
@@ -47,10 +57,32 @@ func main() {
 		"tls":    cfg.TLS.Enabled,
 	}).Info("client configuration loaded")
 
+	var tlsCfg *tls.Config
+	if cfg.TLS.Enabled {
+		if cfg.TLS.Reload {
+			reloadable, err := config.NewReloadableTLS(cfg.TLS, l)
+			if err != nil {
+				log.Fatalf("tls reload config: %v", err)
+			}
+			defer reloadable.Close()
+			tlsCfg = reloadable.ClientConfig()
+		} else {
+			tlsCfg, err = config.ClientTLSConfig(cfg.TLS)
+			if err != nil {
+				log.Fatalf("tls config: %v", err)
+			}
+		}
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	client := tunnel.NewClient(*cfg, l)
+	notifiers, err := tunnel.BuildNotifiers(cfg.Notifiers, l)
+	if err != nil {
+		log.Fatalf("notifiers config: %v", err)
+	}
+
+	client := tunnel.NewClient(*cfg, l, tlsCfg, notifiers...)
 
 	// Close local listener when context cancels by connecting to it to unblock accept if needed.
 	go func() {
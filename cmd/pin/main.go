@@ -0,0 +1,39 @@
+// Command falcon-tunnel-pin connects to a TLS endpoint and prints the SPKI
+// pin (see config.ComputeSPKIPin) for every certificate in the presented
+// chain, so operators can bootstrap a tls.cert_pins config list without
+// hand-computing hashes.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/njangra/falcon-tunnel/internal/config"
+)
+
+func main() {
+	addr := flag.String("addr", "", "host:port to connect to")
+	insecure := flag.Bool("insecure-skip-verify", true, "skip chain verification while inspecting the presented certificates")
+	flag.Parse()
+
+	if *addr == "" {
+		log.Fatalf("-addr is required")
+	}
+
+	conn, err := tls.Dial("tcp", *addr, &tls.Config{InsecureSkipVerify: *insecure})
+	if err != nil {
+		log.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		log.Fatalf("server presented no certificates")
+	}
+
+	for i, cert := range chain {
+		fmt.Printf("%d: %s\n    pin: %s\n", i, cert.Subject, config.ComputeSPKIPin(cert))
+	}
+}
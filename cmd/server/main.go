@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
@@ -22,6 +23,15 @@ func main() {
 		log.Fatalf("parse flags: %v", err)
 	}
 
+	if flags.GenerateCert.Value() {
+		certPath, keyPath, err := config.RunGenerateCert(flags)
+		if err != nil {
+			log.Fatalf("generate cert: %v", err)
+		}
+		fmt.Printf("wrote self-signed certificate to %s and key to %s\n", certPath, keyPath)
+		return
+	}
+
 	ov := config.OverridesFromFlags(flags)
 	configPath := ""
 	if ov.ConfigPath != nil {
@@ -49,9 +59,23 @@ func main() {
 
 	var ln net.Listener
 	if cfg.TLS.Enabled {
-		tlsCfg, err := config.ServerTLSConfig(cfg.TLS)
-		if err != nil {
-			log.Fatalf("tls config: %v", err)
+		if err := config.EnsureSelfSignedCert(cfg.TLS); err != nil {
+			log.Fatalf("tls auto-generate: %v", err)
+		}
+
+		var tlsCfg *tls.Config
+		if cfg.TLS.Reload {
+			reloadable, err := config.NewReloadableTLS(cfg.TLS, l)
+			if err != nil {
+				log.Fatalf("tls reload config: %v", err)
+			}
+			defer reloadable.Close()
+			tlsCfg = reloadable.Config()
+		} else {
+			tlsCfg, err = config.ServerTLSConfig(cfg.TLS)
+			if err != nil {
+				log.Fatalf("tls config: %v", err)
+			}
 		}
 		ln, err = tls.Listen("tcp", cfg.Server.ListenAddr, tlsCfg)
 		if err != nil {
@@ -71,7 +95,12 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	server := tunnel.NewServer(*cfg, nil, l)
+	notifiers, err := tunnel.BuildNotifiers(cfg.Notifiers, l)
+	if err != nil {
+		log.Fatalf("notifiers config: %v", err)
+	}
+
+	server := tunnel.NewServer(*cfg, nil, l, notifiers...)
 
 	go func() {
 		<-ctx.Done()
@@ -13,11 +13,20 @@ import (
 func main() {
 	host := flag.String("host", "localhost", "Comma-separated hostnames or IPs for the certificate")
 	days := flag.Int("days", 365, "Certificate validity in days")
+	keyType := flag.String("keytype", "rsa2048", "Key type: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519")
 	certPath := flag.String("cert", "cert.pem", "Output certificate path")
 	keyPath := flag.String("key", "key.pem", "Output private key path")
+	p12Path := flag.String("pkcs12", "", "Also write a password-protected PKCS#12 (.p12) bundle to this path")
+	p12Password := flag.String("pkcs12-password", "", "Password for the PKCS#12 bundle")
 	flag.Parse()
 
-	cert, key, err := config.GenerateSelfSigned(*host, time.Duration(*days)*24*time.Hour)
+	kt, err := config.ParseKeyType(*keyType)
+	if err != nil {
+		log.Fatalf("generate self-signed cert: %v", err)
+	}
+
+	validFor := time.Duration(*days) * 24 * time.Hour
+	cert, key, err := config.GenerateSelfSignedWithKeyType(*host, validFor, kt)
 	if err != nil {
 		log.Fatalf("generate self-signed cert: %v", err)
 	}
@@ -29,5 +38,16 @@ func main() {
 		log.Fatalf("write key: %v", err)
 	}
 
-	fmt.Printf("Wrote cert: %s\nWrote key: %s\nHosts: %s\nValid: %d days\n", *certPath, *keyPath, *host, *days)
+	fmt.Printf("Wrote cert: %s\nWrote key: %s\nHosts: %s\nKey type: %s\nValid: %d days\n", *certPath, *keyPath, *host, kt, *days)
+
+	if *p12Path != "" {
+		p12, err := config.EncodeSelfSignedPKCS12(cert, key, *p12Password)
+		if err != nil {
+			log.Fatalf("generate pkcs12 bundle: %v", err)
+		}
+		if err := os.WriteFile(*p12Path, p12, 0o600); err != nil {
+			log.Fatalf("write pkcs12 bundle: %v", err)
+		}
+		fmt.Printf("Wrote pkcs12: %s\n", *p12Path)
+	}
 }
@@ -1,23 +1,238 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
 
-// Authenticator will handle password verification and related state.
-type Authenticator struct {
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthRequest carries the credentials and connection context presented by a
+// client during a handshake. Backends read whichever fields they need; a
+// cert-based backend, for instance, ignores Username/Password entirely.
+type AuthRequest struct {
+	Username   string
+	Password   string
+	TLS        *tls.ConnectionState
+	RemoteAddr net.Addr // the tunnel connection's peer address, for backends that enforce a source CIDR
+}
+
+// Authenticator verifies a client's identity against a configured backend
+// and, on success, resolves it to a Principal carrying the identity and
+// permissions the rest of the tunnel should enforce (roles, connection
+// limits, ...).
+type Authenticator interface {
+	Authenticate(req AuthRequest) (*Principal, bool)
+}
+
+// Principal is the resolved identity of a successfully authenticated
+// client. Simple backends (static, cert, bcrypt) only ever populate
+// Username; richer ones (see UserProvider) also carry roles and limits
+// sourced from a configured or externally looked-up user record.
+type Principal struct {
+	Username string
+	Roles    []string
+
+	// Attributes carries free-form key/value pairs an external backend
+	// (e.g. the cmd:// command hook) reported about the user, beyond the
+	// fixed fields above.
+	Attributes map[string]string
+
+	// AllowedCIDRs, IdleTimeout and MaxConnections are per-user limits
+	// resolved alongside identity; a zero value means "no override", i.e.
+	// fall back to the server-wide default. See tunnel.Server.
+	AllowedCIDRs   []string
+	IdleTimeout    time.Duration
+	MaxConnections int
+}
+
+// HasRole reports whether p carries the given role tag.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAuth builds an Authenticator from a URL-scheme spec string:
+//
+//	static://?username=u&password=p
+//	basicfile:///etc/falcon/htpasswd?reload=60s
+//	cert://?cn=alice&password_hash=$2a$...
+//	jsonfile:///etc/falcon/users.json?reload=60s
+//	cmd:///usr/local/bin/falcon-auth-hook?arg=--site&arg=prod&timeout=5s
+//	http://auth.internal/verify?scope=password,ip&cache_ttl=30s
+//	none://
+func NewAuth(spec string) (Authenticator, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parse auth spec %q: %w", spec, err)
+	}
+	switch u.Scheme {
+	case "static":
+		return newStaticAuth(u)
+	case "basicfile":
+		return newBasicFileAuth(u)
+	case "cert":
+		return newCertAuth(u), nil
+	case "jsonfile":
+		return newJSONFileUserProvider(u)
+	case "cmd":
+		return newCommandUserProvider(u)
+	case "http", "https":
+		return newHTTPAuth(u)
+	case "none":
+		return DenyAll{negate: true}, nil
+	default:
+		return nil, fmt.Errorf("unsupported auth scheme %q", u.Scheme)
+	}
+}
+
+// Chain tries each backend in order and succeeds if any one does.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(req AuthRequest) (*Principal, bool) {
+	for _, a := range c {
+		if p, ok := a.Authenticate(req); ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// NewChain builds a Chain from multiple specs, so operators can combine e.g.
+// a static fallback user with an htpasswd file or mTLS backend.
+func NewChain(specs []string) (Chain, error) {
+	chain := make(Chain, 0, len(specs))
+	for _, s := range specs {
+		a, err := NewAuth(s)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	return chain, nil
+}
+
+// DenyAll always fails authentication, unless negate is set, in which case it
+// always succeeds (used for the none:// backend and as a safe fallback for
+// misconfigured backends).
+type DenyAll struct {
+	negate bool
+}
+
+// Authenticate implements Authenticator.
+func (d DenyAll) Authenticate(req AuthRequest) (*Principal, bool) {
+	if !d.negate {
+		return nil, false
+	}
+	return &Principal{Username: req.Username}, true
+}
+
+// staticAuth checks a single configured username/password pair.
+type staticAuth struct {
+	username string
+	password string
+}
+
+func newStaticAuth(u *url.URL) (*staticAuth, error) {
+	q := u.Query()
+	username, password := q.Get("username"), q.Get("password")
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("static auth requires username and password query params")
+	}
+	return &staticAuth{username: username, password: password}, nil
+}
+
+func (a *staticAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	if req.Username != a.username || req.Password != a.password {
+		return nil, false
+	}
+	return &Principal{Username: a.username}, true
+}
+
+// certAuth accepts a connection that presents a verified TLS client
+// certificate. It relies on tls.Config.ClientAuth being set to request or
+// require a client certificate on the tunnel listener (see
+// config.ServerTLSConfig); it does not itself validate the chain.
+//
+// Two optional query parameters narrow what "verified" means in practice,
+// mirroring the cert / cert+password / password modes operators commonly
+// want:
+//
+//	cn            require the leaf certificate's CommonName, or one of its
+//	              DNS SANs, to equal this value (identity pinning)
+//	password_hash additionally require a matching bcrypt password, for
+//	              "certificate and password" two-factor setups
+//
+// Both are optional; cert:// with neither accepts any verified certificate.
+type certAuth struct {
+	commonName   string
 	passwordHash string
 }
 
-// New creates an authenticator with a hashed password.
-func New(hash string) *Authenticator {
-	return &Authenticator{passwordHash: hash}
+func newCertAuth(u *url.URL) *certAuth {
+	q := u.Query()
+	return &certAuth{commonName: q.Get("cn"), passwordHash: q.Get("password_hash")}
 }
 
-// Authenticate validates the provided password against the stored bcrypt hash.
-func (a *Authenticator) Authenticate(password string) bool {
-	if a.passwordHash == "" {
-		return false
+func (a *certAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+	leaf := req.TLS.PeerCertificates[0]
+	if a.commonName != "" && !certHasIdentity(leaf, a.commonName) {
+		return nil, false
+	}
+	if a.passwordHash != "" && bcrypt.CompareHashAndPassword([]byte(a.passwordHash), []byte(req.Password)) != nil {
+		return nil, false
+	}
+	username := a.commonName
+	if username == "" {
+		username = leaf.Subject.CommonName
+	}
+	return &Principal{Username: username}, true
+}
+
+// certHasIdentity reports whether cert's CommonName or any DNS SAN equals name.
+func certHasIdentity(cert *x509.Certificate, name string) bool {
+	if cert.Subject.CommonName == name {
+		return true
+	}
+	for _, san := range cert.DNSNames {
+		if san == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewBcryptAuth builds an Authenticator around a single pre-hashed bcrypt
+// password, ignoring username. It preserves the legacy auth.password_hash
+// config path that predates multi-backend auth.
+func NewBcryptAuth(hash string) Authenticator {
+	return &bcryptAuth{hash: hash}
+}
+
+type bcryptAuth struct {
+	hash string
+}
+
+func (a *bcryptAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	if a.hash == "" {
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(a.hash), []byte(req.Password)) != nil {
+		return nil, false
 	}
-	return bcrypt.CompareHashAndPassword([]byte(a.passwordHash), []byte(password)) == nil
+	return &Principal{Username: req.Username}, true
 }
 
 // HashPassword provides a helper to generate a bcrypt hash for configuration or tests.
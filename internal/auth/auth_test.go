@@ -1,24 +1,165 @@
 package auth
 
-import "testing"
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+)
 
-func TestAuthenticate(t *testing.T) {
+// selfSignedLeaf builds a minimal self-signed certificate for exercising
+// certAuth's identity checks; it doesn't need to chain to a CA, since
+// certAuth trusts whatever tls.Config.ClientAuth already verified.
+func selfSignedLeaf(t *testing.T, commonName string, dnsNames ...string) *x509.Certificate {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestBcryptAuth(t *testing.T) {
 	hash, err := HashPassword("secret")
 	if err != nil {
 		t.Fatalf("hash password: %v", err)
 	}
-	a := New(hash)
-	if !a.Authenticate("secret") {
+	a := NewBcryptAuth(hash)
+	if _, ok := a.Authenticate(AuthRequest{Password: "secret"}); !ok {
 		t.Fatalf("expected password to authenticate")
 	}
-	if a.Authenticate("wrong") {
+	if _, ok := a.Authenticate(AuthRequest{Password: "wrong"}); ok {
 		t.Fatalf("expected authentication to fail for wrong password")
 	}
 }
 
-func TestAuthenticateEmptyHash(t *testing.T) {
-	a := New("")
-	if a.Authenticate("anything") {
+func TestBcryptAuthEmptyHash(t *testing.T) {
+	a := NewBcryptAuth("")
+	if _, ok := a.Authenticate(AuthRequest{Password: "anything"}); ok {
 		t.Fatalf("expected empty hash to fail authentication")
 	}
 }
+
+func TestNewAuthStatic(t *testing.T) {
+	a, err := NewAuth("static://?username=alice&password=hunter2")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "hunter2"}); !ok {
+		t.Fatalf("expected matching credentials to authenticate")
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+}
+
+func TestNewAuthNone(t *testing.T) {
+	a, err := NewAuth("none://")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{}); !ok {
+		t.Fatalf("expected none:// backend to always authenticate")
+	}
+}
+
+func TestNewAuthUnsupportedScheme(t *testing.T) {
+	if _, err := NewAuth("ldap://example.com"); err == nil {
+		t.Fatalf("expected error for unsupported scheme")
+	}
+}
+
+func TestChainTriesEachBackend(t *testing.T) {
+	chain, err := NewChain([]string{
+		"static://?username=bob&password=pw1",
+		"static://?username=alice&password=pw2",
+	})
+	if err != nil {
+		t.Fatalf("new chain: %v", err)
+	}
+	if _, ok := chain.Authenticate(AuthRequest{Username: "alice", Password: "pw2"}); !ok {
+		t.Fatalf("expected second backend in chain to authenticate")
+	}
+	if _, ok := chain.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected no backend to authenticate wrong credentials")
+	}
+}
+
+func TestCertAuth(t *testing.T) {
+	a, err := NewAuth("cert://")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{}); ok {
+		t.Fatalf("expected cert auth to fail without peer certificates")
+	}
+	leaf := selfSignedLeaf(t, "alice", "alice.example.com")
+	if _, ok := a.Authenticate(AuthRequest{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}}); !ok {
+		t.Fatalf("expected cert auth to accept any verified certificate")
+	}
+}
+
+func TestCertAuthCommonNameMatch(t *testing.T) {
+	a, err := NewAuth("cert://?cn=alice")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	req := AuthRequest{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{selfSignedLeaf(t, "alice")}}}
+	if _, ok := a.Authenticate(req); !ok {
+		t.Fatalf("expected matching CommonName to authenticate")
+	}
+	req = AuthRequest{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{selfSignedLeaf(t, "mallory")}}}
+	if _, ok := a.Authenticate(req); ok {
+		t.Fatalf("expected mismatched CommonName to fail")
+	}
+}
+
+func TestCertAuthDNSSANMatch(t *testing.T) {
+	a, err := NewAuth("cert://?cn=alice.example.com")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	req := AuthRequest{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+		selfSignedLeaf(t, "alice", "alice.example.com"),
+	}}}
+	if _, ok := a.Authenticate(req); !ok {
+		t.Fatalf("expected matching DNS SAN to authenticate")
+	}
+}
+
+func TestCertAuthPlusPassword(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	a, err := NewAuth("cert://?password_hash=" + url.QueryEscape(hash))
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	leaf := selfSignedLeaf(t, "alice")
+	req := AuthRequest{Password: "hunter2", TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}}
+	if _, ok := a.Authenticate(req); !ok {
+		t.Fatalf("expected cert + matching password to authenticate")
+	}
+	req.Password = "wrong"
+	if _, ok := a.Authenticate(req); ok {
+		t.Fatalf("expected cert + wrong password to fail")
+	}
+}
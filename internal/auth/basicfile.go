@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultReloadInterval = 60 * time.Second
+
+// basicFileAuth checks credentials against an Apache htpasswd-style file,
+// reloading it on an interval so operators can add or rotate users without a
+// restart. Lookups are guarded by an RWMutex so reloads never block readers
+// for longer than the map swap itself.
+type basicFileAuth struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string // username -> htpasswd hash
+}
+
+func newBasicFileAuth(u *url.URL) (*basicFileAuth, error) {
+	reload := defaultReloadInterval
+	if r := u.Query().Get("reload"); r != "" {
+		d, err := time.ParseDuration(r)
+		if err != nil {
+			return nil, fmt.Errorf("parse basicfile reload interval: %w", err)
+		}
+		reload = d
+	}
+
+	a := &basicFileAuth{path: u.Path}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	if reload > 0 {
+		go a.watch(reload)
+	}
+	return a, nil
+}
+
+func (a *basicFileAuth) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		_ = a.load()
+	}
+}
+
+func (a *basicFileAuth) load() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("open htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read htpasswd file: %w", err)
+	}
+
+	a.mu.Lock()
+	a.users = users
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *basicFileAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	a.mu.RLock()
+	hash, ok := a.users[req.Username]
+	a.mu.RUnlock()
+	if !ok || !verifyHtpasswd(hash, req.Password) {
+		return nil, false
+	}
+	return &Principal{Username: req.Username}, true
+}
+
+// verifyHtpasswd supports the htpasswd hash formats operators actually use in
+// the wild: bcrypt ($2a$/$2b$/$2y$), legacy crypt(3) SHA1 ({SHA}), and the
+// Apache-specific APR1-MD5 ($apr1$) variant.
+func verifyHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return hash == "{SHA}"+base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		return apr1MD5(password, hash) == hash
+	default:
+		return false
+	}
+}
+
+// apr1MD5 reimplements Apache's APR1-MD5 crypt variant, returning a digest in
+// the same "$apr1$salt$hash" form as the input so callers can compare directly.
+func apr1MD5(password, apr1Hash string) string {
+	parts := strings.SplitN(apr1Hash, "$", 4)
+	if len(parts) != 4 {
+		return ""
+	}
+	salt := parts[2]
+
+	ctx := md5.New()
+	ctx.Write([]byte(password))
+	ctx.Write([]byte("$apr1$"))
+	ctx.Write([]byte(salt))
+
+	ctx2 := md5.New()
+	ctx2.Write([]byte(password))
+	ctx2.Write([]byte(salt))
+	ctx2.Write([]byte(password))
+	final := ctx2.Sum(nil)
+
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(final)
+		} else {
+			ctx.Write(final[:i])
+		}
+	}
+
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write([]byte(password[:1]))
+		}
+	}
+
+	digest := ctx.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		ctx2 := md5.New()
+		if i&1 != 0 {
+			ctx2.Write([]byte(password))
+		} else {
+			ctx2.Write(digest)
+		}
+		if i%3 != 0 {
+			ctx2.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			ctx2.Write([]byte(password))
+		}
+		if i&1 != 0 {
+			ctx2.Write(digest)
+		} else {
+			ctx2.Write([]byte(password))
+		}
+		digest = ctx2.Sum(nil)
+	}
+
+	return "$apr1$" + salt + "$" + apr1Base64(digest)
+}
+
+// apr1Base64 encodes using the custom base64 alphabet and byte order that
+// crypt(3) APR1 uses, which differs from encoding/base64's standard alphabet.
+func apr1Base64(digest []byte) string {
+	const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	triples := [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}
+	var out strings.Builder
+	for _, t := range triples {
+		v := uint32(digest[t[0]])<<16 | uint32(digest[t[1]])<<8 | uint32(digest[t[2]])
+		for n := 0; n < 4; n++ {
+			out.WriteByte(alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	v := uint32(digest[11])
+	for n := 0; n < 2; n++ {
+		out.WriteByte(alphabet[v&0x3f])
+		v >>= 6
+	}
+	return out.String()[:22]
+}
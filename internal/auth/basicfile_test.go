@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBasicFileAuthBcrypt(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:"+hash+"\n"), 0o644); err != nil {
+		t.Fatalf("write htpasswd: %v", err)
+	}
+
+	a, err := NewAuth("basicfile://" + path + "?reload=0")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"}); !ok {
+		t.Fatalf("expected alice to authenticate")
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "bob", Password: "secret"}); ok {
+		t.Fatalf("expected unknown user to fail")
+	}
+}
+
+func TestBasicFileAuthReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "htpasswd")
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("alice:"+hash+"\n"), 0o644); err != nil {
+		t.Fatalf("write htpasswd: %v", err)
+	}
+
+	a, err := NewAuth("basicfile://" + path + "?reload=0")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	bf := a.(*basicFileAuth)
+
+	if err := os.WriteFile(path, []byte("alice:"+hash+"\nbob:"+hash+"\n"), 0o644); err != nil {
+		t.Fatalf("rewrite htpasswd: %v", err)
+	}
+	if err := bf.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := bf.Authenticate(AuthRequest{Username: "bob", Password: "secret"}); !ok {
+		t.Fatalf("expected newly added user to authenticate after reload")
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExternalAuthScope selects which AuthRequest fields an external
+// authenticator (cmd:// or http://) forwards to its hook, mirroring
+// SFTPGo's external-auth-scope bitmask so operators can avoid leaking a
+// client IP or TLS fingerprint to a hook that has no use for it.
+type ExternalAuthScope int
+
+const (
+	ScopePassword ExternalAuthScope = 1 << iota
+	ScopeClientIP
+	ScopeTLSFingerprint
+	ScopeSNI
+)
+
+// parseExternalAuthScope maps a comma-separated "scope" query value (e.g.
+// "password,ip,fingerprint,sni") to an ExternalAuthScope. An empty string
+// defaults to ScopePassword, preserving cmd://'s original username/password
+// only behavior.
+func parseExternalAuthScope(s string) (ExternalAuthScope, error) {
+	if s == "" {
+		return ScopePassword, nil
+	}
+	var scope ExternalAuthScope
+	for _, part := range strings.Split(s, ",") {
+		switch strings.TrimSpace(part) {
+		case "password":
+			scope |= ScopePassword
+		case "ip":
+			scope |= ScopeClientIP
+		case "fingerprint":
+			scope |= ScopeTLSFingerprint
+		case "sni":
+			scope |= ScopeSNI
+		default:
+			return 0, fmt.Errorf("unknown external auth scope %q", part)
+		}
+	}
+	return scope, nil
+}
+
+// externalAuthPayload is the JSON request body sent to an external
+// authenticator, shared by the cmd:// and http(s):// backends. Fields are
+// only populated when ExternalAuthScope selects them, so a hook never
+// receives more than it asked for.
+type externalAuthPayload struct {
+	Username       string `json:"username"`
+	Password       string `json:"password,omitempty"`
+	ClientIP       string `json:"client_ip,omitempty"`
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+	SNI            string `json:"sni,omitempty"`
+}
+
+func buildExternalAuthPayload(req AuthRequest, scope ExternalAuthScope) externalAuthPayload {
+	payload := externalAuthPayload{Username: req.Username}
+	if scope&ScopePassword != 0 {
+		payload.Password = req.Password
+	}
+	if scope&ScopeClientIP != 0 && req.RemoteAddr != nil {
+		payload.ClientIP = req.RemoteAddr.String()
+	}
+	if scope&ScopeTLSFingerprint != 0 && req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+		payload.TLSFingerprint = hex.EncodeToString(sum[:])
+	}
+	if scope&ScopeSNI != 0 && req.TLS != nil {
+		payload.SNI = req.TLS.ServerName
+	}
+	return payload
+}
+
+// externalAuthVerdict is the JSON response expected from an external
+// authenticator, shared by the cmd:// and http(s):// backends.
+type externalAuthVerdict struct {
+	Allow          bool              `json:"allow"`
+	Roles          []string          `json:"roles"`
+	Attributes     map[string]string `json:"attributes"`
+	AllowedCIDRs   []string          `json:"allowed_cidrs"`
+	IdleTimeout    string            `json:"idle_timeout"`
+	MaxConnections int               `json:"max_connections"`
+}
+
+func (v externalAuthVerdict) principal(req AuthRequest) (*Principal, error) {
+	var idleTimeout time.Duration
+	if v.IdleTimeout != "" {
+		var err error
+		idleTimeout, err = time.ParseDuration(v.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse idle_timeout: %w", err)
+		}
+	}
+	if !sourceAllowed(v.AllowedCIDRs, req.RemoteAddr) {
+		return nil, fmt.Errorf("source not allowed")
+	}
+	return &Principal{
+		Username:       req.Username,
+		Roles:          v.Roles,
+		Attributes:     v.Attributes,
+		AllowedCIDRs:   v.AllowedCIDRs,
+		IdleTimeout:    idleTimeout,
+		MaxConnections: v.MaxConnections,
+	}, nil
+}
+
+// cachedDecision is one TTL-cached external-auth verdict.
+type cachedDecision struct {
+	principal *Principal
+	ok        bool
+	expiresAt time.Time
+}
+
+// cachingAuth wraps another Authenticator and remembers its verdict for ttl,
+// keyed by whatever fields scope actually sends to the wrapped
+// authenticator, so a reconnecting FTP client doesn't re-invoke a slow
+// exec/HTTP hook on every control connection. Fields outside scope (most
+// commonly ScopeClientIP, since the source port - and often the IP, for a
+// client behind NAT or reconnecting over a new route - changes on every
+// reconnect) are left out of the key, or the cache would never hit for the
+// reconnect scenario it exists to speed up. Expired entries are dropped
+// lazily on access and by a background sweep (see sweepExpired), so the
+// cache doesn't grow without bound over a long-lived server's uptime.
+type cachingAuth struct {
+	next  Authenticator
+	ttl   time.Duration
+	scope ExternalAuthScope
+
+	mu    sync.Mutex
+	cache map[string]cachedDecision
+}
+
+func newCachingAuth(next Authenticator, ttl time.Duration, scope ExternalAuthScope) *cachingAuth {
+	c := &cachingAuth{next: next, ttl: ttl, scope: scope, cache: make(map[string]cachedDecision)}
+	go c.sweepExpired()
+	return c
+}
+
+func (c *cachingAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	key := cacheKey(req, c.scope)
+
+	c.mu.Lock()
+	if d, ok := c.cache[key]; ok {
+		if time.Now().Before(d.expiresAt) {
+			c.mu.Unlock()
+			return d.principal, d.ok
+		}
+		delete(c.cache, key)
+	}
+	c.mu.Unlock()
+
+	principal, ok := c.next.Authenticate(req)
+
+	c.mu.Lock()
+	c.cache[key] = cachedDecision{principal: principal, ok: ok, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return principal, ok
+}
+
+// sweepExpired periodically drops expired cache entries, so a
+// (user, password, ip, fingerprint, sni) tuple that's never presented again
+// after expiring - a typo, a scan, a client that never reconnects - doesn't
+// sit in memory forever waiting for an access that will never come. It
+// runs for the lifetime of the process, matching cachingAuth's own
+// lifetime; there is no Stop, as Authenticator has no shutdown hook.
+func (c *cachingAuth) sweepExpired() {
+	if c.ttl <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.mu.Lock()
+		for key, d := range c.cache {
+			if now.After(d.expiresAt) {
+				delete(c.cache, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// cacheKey identifies a request for caching purposes. Username is always
+// included; every other field is included only when scope actually sends
+// it to the wrapped hook, so e.g. the client's ephemeral source port
+// (folded into RemoteAddr) doesn't make every reconnect miss the cache when
+// the hook was never configured to see the client IP in the first place.
+// The password is hashed rather than stored verbatim, so a long-lived
+// cache never retains plaintext credentials.
+func cacheKey(req AuthRequest, scope ExternalAuthScope) string {
+	parts := []string{req.Username}
+
+	if scope&ScopePassword != 0 {
+		passwordHash := sha256.Sum256([]byte(req.Password))
+		parts = append(parts, hex.EncodeToString(passwordHash[:]))
+	}
+	if scope&ScopeClientIP != 0 && req.RemoteAddr != nil {
+		parts = append(parts, req.RemoteAddr.String())
+	}
+	if scope&ScopeTLSFingerprint != 0 && req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+		parts = append(parts, hex.EncodeToString(sum[:]))
+	}
+	if scope&ScopeSNI != 0 && req.TLS != nil {
+		parts = append(parts, req.TLS.ServerName)
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultHTTPAuthTimeout = 5 * time.Second
+
+// httpAuth authenticates by POSTing the presented credentials as JSON to a
+// configured URL and expecting the same JSON verdict shape as commandAuth,
+// for operators who'd rather run a small auth microservice than an
+// exec'd binary. Accepts the same scope/cache_ttl query parameters as
+// cmd://; see commandAuth.
+type httpAuth struct {
+	url     string
+	timeout time.Duration
+	scope   ExternalAuthScope
+	client  *http.Client
+}
+
+func newHTTPAuth(u *url.URL) (Authenticator, error) {
+	q := u.Query()
+
+	timeout := defaultHTTPAuthTimeout
+	if t := q.Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("parse http auth timeout: %w", err)
+		}
+		timeout = d
+	}
+
+	scope, err := parseExternalAuthScope(q.Get("scope"))
+	if err != nil {
+		return nil, fmt.Errorf("http auth: %w", err)
+	}
+
+	// The scope/cache_ttl/timeout query params are falcon's own and aren't
+	// meaningful to the remote endpoint, so strip them before dialing out.
+	target := *u
+	target.RawQuery = ""
+
+	a := &httpAuth{
+		url:     target.String(),
+		timeout: timeout,
+		scope:   scope,
+		client:  &http.Client{Timeout: timeout},
+	}
+	return wrapWithCache(a, q.Get("cache_ttl"), scope)
+}
+
+func (a *httpAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	payload, err := json.Marshal(buildExternalAuthPayload(req, a.scope))
+	if err != nil {
+		return nil, false
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, false
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, false
+	}
+
+	var verdict externalAuthVerdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil || !verdict.Allow {
+		return nil, false
+	}
+
+	principal, err := verdict.principal(req)
+	if err != nil {
+		return nil, false
+	}
+	return principal, true
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExternalAuthScopeDefaultsToPassword(t *testing.T) {
+	scope, err := parseExternalAuthScope("")
+	if err != nil {
+		t.Fatalf("parse scope: %v", err)
+	}
+	if scope != ScopePassword {
+		t.Fatalf("expected default scope to be ScopePassword, got %v", scope)
+	}
+}
+
+func TestParseExternalAuthScopeCombinesFlags(t *testing.T) {
+	scope, err := parseExternalAuthScope("password,ip,fingerprint,sni")
+	if err != nil {
+		t.Fatalf("parse scope: %v", err)
+	}
+	want := ScopePassword | ScopeClientIP | ScopeTLSFingerprint | ScopeSNI
+	if scope != want {
+		t.Fatalf("scope = %v, want %v", scope, want)
+	}
+}
+
+func TestParseExternalAuthScopeRejectsUnknown(t *testing.T) {
+	if _, err := parseExternalAuthScope("password,carrier-pigeon"); err == nil {
+		t.Fatalf("expected error for unknown scope flag")
+	}
+}
+
+func TestBuildExternalAuthPayloadHonorsScope(t *testing.T) {
+	req := AuthRequest{Username: "alice", Password: "secret", RemoteAddr: fakeAddr("10.0.0.1:1234")}
+
+	payload := buildExternalAuthPayload(req, ScopePassword)
+	if payload.Password != "secret" || payload.ClientIP != "" {
+		t.Fatalf("unexpected payload for ScopePassword: %+v", payload)
+	}
+
+	payload = buildExternalAuthPayload(req, ScopeClientIP)
+	if payload.Password != "" || payload.ClientIP != "10.0.0.1:1234" {
+		t.Fatalf("unexpected payload for ScopeClientIP: %+v", payload)
+	}
+}
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+func TestHTTPAuthAllow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload externalAuthPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		if payload.Username != "alice" || payload.Password != "secret" {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+		json.NewEncoder(w).Encode(externalAuthVerdict{Allow: true, Roles: []string{"proxy"}})
+	}))
+	defer srv.Close()
+
+	a, err := NewAuth(srv.URL)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	p, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"})
+	if !ok {
+		t.Fatalf("expected http hook to authenticate")
+	}
+	if !p.HasRole("proxy") {
+		t.Fatalf("expected proxy role, got %v", p.Roles)
+	}
+}
+
+func TestHTTPAuthDeny(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(externalAuthVerdict{Allow: false})
+	}))
+	defer srv.Close()
+
+	a, err := NewAuth(srv.URL)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected http hook denial to fail authentication")
+	}
+}
+
+func TestHTTPAuthNonOKStatusDenies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	a, err := NewAuth(srv.URL)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"}); ok {
+		t.Fatalf("expected non-2xx status to fail authentication")
+	}
+}
+
+func TestCachingAuthReusesDecisionWithinTTL(t *testing.T) {
+	calls := 0
+	inner := authenticatorFunc(func(req AuthRequest) (*Principal, bool) {
+		calls++
+		return &Principal{Username: req.Username}, true
+	})
+
+	cached := newCachingAuth(inner, time.Minute, ScopePassword)
+	req := AuthRequest{Username: "alice", Password: "secret"}
+
+	if _, ok := cached.Authenticate(req); !ok {
+		t.Fatalf("expected first call to authenticate")
+	}
+	if _, ok := cached.Authenticate(req); !ok {
+		t.Fatalf("expected second call to authenticate")
+	}
+	if calls != 1 {
+		t.Fatalf("expected inner authenticator to be called once, got %d", calls)
+	}
+}
+
+func TestCachingAuthExpiresAfterTTL(t *testing.T) {
+	calls := 0
+	inner := authenticatorFunc(func(req AuthRequest) (*Principal, bool) {
+		calls++
+		return &Principal{Username: req.Username}, true
+	})
+
+	cached := newCachingAuth(inner, time.Millisecond, ScopePassword)
+	req := AuthRequest{Username: "alice", Password: "secret"}
+
+	cached.Authenticate(req)
+	time.Sleep(5 * time.Millisecond)
+	cached.Authenticate(req)
+
+	if calls != 2 {
+		t.Fatalf("expected inner authenticator to be called twice after expiry, got %d", calls)
+	}
+}
+
+func TestCacheKeyDoesNotContainPlaintextPassword(t *testing.T) {
+	key := cacheKey(AuthRequest{Username: "alice", Password: "hunter2"}, ScopePassword)
+	if strings.Contains(key, "hunter2") {
+		t.Fatalf("cache key leaked plaintext password: %q", key)
+	}
+}
+
+func TestCacheKeyExcludesFieldsOutsideScope(t *testing.T) {
+	req := AuthRequest{Username: "alice", Password: "secret", RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}}
+	if got, want := cacheKey(req, ScopePassword), cacheKey(AuthRequest{Username: "alice", Password: "secret"}, ScopePassword); got != want {
+		t.Fatalf("expected RemoteAddr to be excluded from the key when scope doesn't include ScopeClientIP: %q != %q", got, want)
+	}
+}
+
+func TestCachingAuthReusesDecisionAcrossReconnectWhenIPNotInScope(t *testing.T) {
+	calls := 0
+	inner := authenticatorFunc(func(req AuthRequest) (*Principal, bool) {
+		calls++
+		return &Principal{Username: req.Username}, true
+	})
+
+	cached := newCachingAuth(inner, time.Minute, ScopePassword)
+
+	first := AuthRequest{Username: "alice", Password: "secret", RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51000}}
+	if _, ok := cached.Authenticate(first); !ok {
+		t.Fatalf("expected first call to authenticate")
+	}
+
+	// Simulate an FTP reconnect: same credentials, new ephemeral source port.
+	reconnect := AuthRequest{Username: "alice", Password: "secret", RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51999}}
+	if _, ok := cached.Authenticate(reconnect); !ok {
+		t.Fatalf("expected reconnect to authenticate")
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected inner authenticator to be called once across a reconnect, got %d", calls)
+	}
+}
+
+func TestCachingAuthSweepReclaimsUnvisitedEntries(t *testing.T) {
+	inner := authenticatorFunc(func(req AuthRequest) (*Principal, bool) {
+		return &Principal{Username: req.Username}, true
+	})
+
+	cached := newCachingAuth(inner, time.Millisecond, ScopePassword)
+	// Each request is for a distinct, never-repeated user, so nothing ever
+	// re-accesses these entries to trigger the lazy eviction in Authenticate;
+	// only the background sweep can reclaim them.
+	for i := 0; i < 5; i++ {
+		cached.Authenticate(AuthRequest{Username: fmt.Sprintf("user-%d", i), Password: "secret"})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cached.mu.Lock()
+		n := len(cached.cache)
+		cached.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected sweep to reclaim all expired entries, %d remain", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCmdAuthWrapsInCacheWhenTTLSet(t *testing.T) {
+	hook := writeFakeAuthHook(t, `{"allow":true}`)
+
+	a, err := NewAuth("cmd://" + hook + "?cache_ttl=1h")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.(*cachingAuth); !ok {
+		t.Fatalf("expected cache_ttl to wrap commandAuth in a cachingAuth, got %T", a)
+	}
+}
+
+// authenticatorFunc adapts a plain func to the Authenticator interface for
+// tests, mirroring the stdlib's http.HandlerFunc idiom.
+type authenticatorFunc func(AuthRequest) (*Principal, bool)
+
+func (f authenticatorFunc) Authenticate(req AuthRequest) (*Principal, bool) { return f(req) }
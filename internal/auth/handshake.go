@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,10 +22,30 @@ var (
 	ErrTokenGeneration = errors.New("failed generating session token")
 )
 
-// HandshakeServer performs a simple password-based authentication handshake.
-// It expects an auth message containing the plaintext password, verifies it,
-// and responds with MsgAuthResponse ("ok" or error text).
-func HandshakeServer(conn net.Conn, authenticator *Authenticator, deadline time.Duration) error {
+// HandshakeServer performs a username/password authentication handshake, or
+// a token-based resumption in place of one. Both MsgAuth and MsgResume
+// payloads carry a leading protocol.Version byte, checked and stripped
+// before anything else; a mismatch fails the handshake with
+// MsgAuthResponse rather than risk misparsing a frame layout this build
+// doesn't understand. It then expects either an MsgAuth message containing
+// a length-prefixed username followed by the password (see
+// encodeAuthPayload), or an MsgResume message
+// carrying a previously issued token, verifies it (plus the peer TLS state,
+// for backends that care) and responds with MsgAuthResponse ("ok",
+// optionally followed by "\x00<hex-token>", or an error string).
+//
+// store may be nil, in which case authentication still works but no token
+// is issued and resumption is unavailable. On success HandshakeServer
+// returns the token now bound to this session (empty if store is nil), so
+// the caller can later clear it via store.Delete once the client disconnects
+// cleanly. It also returns the presented token when the client resumed an
+// existing session (empty for a fresh MsgAuth login), so the caller can look
+// up and reattach any state it kept around for that token. principal is the
+// Principal returned by the backend on a fresh MsgAuth login; a resumed
+// session has none (the original login's limits aren't re-resolved), so
+// callers needing per-user limits across a resume should fall back to
+// their own server-wide defaults.
+func HandshakeServer(conn net.Conn, authenticator Authenticator, store TokenStore, deadline time.Duration) (principal *Principal, token, resumedFrom string, err error) {
 	if deadline > 0 {
 		_ = conn.SetDeadline(time.Now().Add(deadline))
 	}
@@ -31,59 +53,165 @@ func HandshakeServer(conn net.Conn, authenticator *Authenticator, deadline time.
 
 	msg, err := protocol.Decode(conn)
 	if err != nil {
-		return fmt.Errorf("read auth message: %w", err)
+		return nil, "", "", fmt.Errorf("read auth message: %w", err)
 	}
-	if msg.Type != protocol.MsgAuth {
-		return ErrInvalidResponse
+	if len(msg.Payload) < 1 {
+		return nil, "", "", ErrInvalidResponse
 	}
-	password := string(msg.Payload)
-	var response []byte
-	if authenticator.Authenticate(password) {
-		response = []byte("ok")
-	} else {
-		response = []byte("invalid credentials")
+	if peerVersion := msg.Payload[0]; peerVersion != protocol.Version {
+		return nil, "", "", writeAuthFailure(conn, fmt.Sprintf("incompatible protocol version %d", peerVersion))
 	}
+	msg.Payload = msg.Payload[1:]
 
+	var username string
+	switch msg.Type {
+	case protocol.MsgAuth:
+		var password string
+		username, password, err = decodeAuthPayload(msg.Payload)
+		if err != nil {
+			return nil, "", "", err
+		}
+		req := AuthRequest{Username: username, Password: password, RemoteAddr: conn.RemoteAddr()}
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+		}
+		p, ok := authenticator.Authenticate(req)
+		if !ok {
+			return nil, "", "", writeAuthFailure(conn, "invalid credentials")
+		}
+		principal = p
+	case protocol.MsgResume:
+		if store == nil {
+			return nil, "", "", writeAuthFailure(conn, "resumption not supported")
+		}
+		info, ok := store.Validate(string(msg.Payload))
+		if !ok {
+			return nil, "", "", writeAuthFailure(conn, "invalid or expired token")
+		}
+		username = info.Username
+		resumedFrom = string(msg.Payload)
+	default:
+		return nil, "", "", ErrInvalidResponse
+	}
+
+	newToken, err := issueToken(store, username, string(msg.Payload), resumedFrom != "")
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	response := []byte("ok")
+	if newToken != "" {
+		response = append(response, 0)
+		response = append(response, newToken...)
+	}
 	respFrame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAuthResponse, Payload: response})
 	if err != nil {
-		return err
+		return nil, "", "", err
 	}
 	if _, err := conn.Write(respFrame); err != nil {
-		return fmt.Errorf("write auth response: %w", err)
+		return nil, "", "", fmt.Errorf("write auth response: %w", err)
 	}
+	return principal, newToken, resumedFrom, nil
+}
 
-	if string(response) != "ok" {
-		return ErrAuthFailed
+// issueToken mints a fresh token for a new login, or rotates the presented
+// one for a resume, so a token is never reused across reconnects.
+func issueToken(store TokenStore, username, presented string, resuming bool) (string, error) {
+	if store == nil {
+		return "", nil
+	}
+	newToken, err := GenerateToken(tokenLength, nil)
+	if err != nil {
+		return "", err
+	}
+	if resuming {
+		if _, ok := store.Rotate(presented, newToken, defaultTokenTTL); !ok {
+			return "", ErrAuthFailed
+		}
+		return newToken, nil
 	}
-	return nil
+	store.Put(newToken, TokenInfo{Username: username, Expiry: time.Now().Add(defaultTokenTTL)})
+	return newToken, nil
 }
 
-// HandshakeClient sends plaintext password and expects "ok" in response.
-func HandshakeClient(conn net.Conn, password string, deadline time.Duration) error {
+// writeAuthFailure sends an MsgAuthResponse carrying reason and returns
+// ErrAuthFailed, so callers can `return nil, "", "", writeAuthFailure(conn, reason)`.
+func writeAuthFailure(conn net.Conn, reason string) error {
+	frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAuthResponse, Payload: []byte(reason)})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(frame); err != nil {
+		return fmt.Errorf("write auth response: %w", err)
+	}
+	return ErrAuthFailed
+}
+
+// HandshakeClient sends username and password and expects "ok" (optionally
+// followed by a resumption token) in response. It returns the token, which
+// is empty if the server has no TokenStore configured.
+func HandshakeClient(conn net.Conn, username, password string, deadline time.Duration) (string, error) {
+	return sendAuthMessage(conn, protocol.Message{Type: protocol.MsgAuth, Payload: encodeAuthPayload(username, password)}, deadline)
+}
+
+// ResumeClient presents a previously issued token in place of a password.
+// On success it returns the rotated token the client must use for the next
+// resume; on failure (e.g. an expired token) the caller should fall back to
+// HandshakeClient.
+func ResumeClient(conn net.Conn, token string, deadline time.Duration) (string, error) {
+	return sendAuthMessage(conn, protocol.Message{Type: protocol.MsgResume, Payload: []byte(token)}, deadline)
+}
+
+func sendAuthMessage(conn net.Conn, msg protocol.Message, deadline time.Duration) (string, error) {
 	if deadline > 0 {
 		_ = conn.SetDeadline(time.Now().Add(deadline))
 	}
 	defer conn.SetDeadline(time.Time{})
 
-	frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAuth, Payload: []byte(password)})
+	msg.Payload = append([]byte{protocol.Version}, msg.Payload...)
+	frame, err := protocol.Encode(msg)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if _, err := conn.Write(frame); err != nil {
-		return fmt.Errorf("send auth: %w", err)
+		return "", fmt.Errorf("send auth: %w", err)
 	}
 
 	resp, err := protocol.Decode(conn)
 	if err != nil {
-		return fmt.Errorf("read auth response: %w", err)
+		return "", fmt.Errorf("read auth response: %w", err)
 	}
 	if resp.Type != protocol.MsgAuthResponse {
-		return ErrInvalidResponse
+		return "", ErrInvalidResponse
+	}
+	status, token, _ := bytes.Cut(resp.Payload, []byte{0})
+	if string(status) != "ok" {
+		return "", ErrAuthFailed
+	}
+	return string(token), nil
+}
+
+// encodeAuthPayload packs username and password into a single MsgAuth
+// payload as [2 byte username length][username][password].
+func encodeAuthPayload(username, password string) []byte {
+	buf := make([]byte, 0, 2+len(username)+len(password))
+	buf = append(buf, byte(len(username)>>8), byte(len(username)))
+	buf = append(buf, username...)
+	buf = append(buf, password...)
+	return buf
+}
+
+// decodeAuthPayload reverses encodeAuthPayload.
+func decodeAuthPayload(payload []byte) (username, password string, err error) {
+	if len(payload) < 2 {
+		return "", "", ErrInvalidResponse
 	}
-	if string(resp.Payload) != "ok" {
-		return ErrAuthFailed
+	ulen := int(payload[0])<<8 | int(payload[1])
+	if len(payload) < 2+ulen {
+		return "", "", ErrInvalidResponse
 	}
-	return nil
+	return string(payload[2 : 2+ulen]), string(payload[2+ulen:]), nil
 }
 
 // GenerateToken produces a random session token of n bytes (hex encoded).
@@ -5,6 +5,8 @@ import (
 	"net"
 	"testing"
 	"time"
+
+	"github.com/njangra/falcon-tunnel/pkg/protocol"
 )
 
 func TestHandshakeSuccess(t *testing.T) {
@@ -13,17 +15,17 @@ func TestHandshakeSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatalf("hash password: %v", err)
 	}
-	serverAuth := New(hash)
+	serverAuth := NewBcryptAuth(hash)
 
 	serverConn, clientConn := net.Pipe()
 	defer serverConn.Close()
 	defer clientConn.Close()
 
 	go func() {
-		_ = HandshakeServer(serverConn, serverAuth, time.Second)
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, nil, time.Second)
 	}()
 
-	if err := HandshakeClient(clientConn, pass, time.Second); err != nil {
+	if _, err := HandshakeClient(clientConn, "ignored-user", pass, time.Second); err != nil {
 		t.Fatalf("client handshake: %v", err)
 	}
 }
@@ -34,21 +36,251 @@ func TestHandshakeFailure(t *testing.T) {
 	if err != nil {
 		t.Fatalf("hash password: %v", err)
 	}
-	serverAuth := New(hash)
+	serverAuth := NewBcryptAuth(hash)
 
 	serverConn, clientConn := net.Pipe()
 	defer serverConn.Close()
 	defer clientConn.Close()
 
 	go func() {
-		_ = HandshakeServer(serverConn, serverAuth, time.Second)
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, nil, time.Second)
 	}()
 
-	if err := HandshakeClient(clientConn, "badpass", time.Second); !errors.Is(err, ErrAuthFailed) {
+	if _, err := HandshakeClient(clientConn, "ignored-user", "badpass", time.Second); !errors.Is(err, ErrAuthFailed) {
 		t.Fatalf("expected auth failure, got %v", err)
 	}
 }
 
+func TestHandshakeUsesUsername(t *testing.T) {
+	serverAuth, err := NewAuth("static://?username=alice&password=secret")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, nil, time.Second)
+	}()
+
+	if _, err := HandshakeClient(clientConn, "alice", "secret", time.Second); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+}
+
+func TestHandshakeWithoutStoreIssuesNoToken(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverTokens := make(chan string, 1)
+	go func() {
+		_, token, _, _ := HandshakeServer(serverConn, serverAuth, nil, time.Second)
+		serverTokens <- token
+	}()
+
+	clientToken, err := HandshakeClient(clientConn, "user", "secret", time.Second)
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if clientToken != "" {
+		t.Fatalf("expected no token without a store, got %q", clientToken)
+	}
+	if got := <-serverTokens; got != "" {
+		t.Fatalf("expected server to report no token, got %q", got)
+	}
+}
+
+func TestHandshakeWithStoreIssuesToken(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+	store := NewMemoryTokenStore()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverTokens := make(chan string, 1)
+	go func() {
+		_, token, _, _ := HandshakeServer(serverConn, serverAuth, store, time.Second)
+		serverTokens <- token
+	}()
+
+	clientToken, err := HandshakeClient(clientConn, "user", "secret", time.Second)
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if clientToken == "" {
+		t.Fatalf("expected a resumption token")
+	}
+	if got := <-serverTokens; got != clientToken {
+		t.Fatalf("expected server and client to agree on the token, got %q vs %q", got, clientToken)
+	}
+	if _, ok := store.Validate(clientToken); !ok {
+		t.Fatalf("expected token to be valid in the store")
+	}
+}
+
+func TestResumeClientRotatesToken(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+	store := NewMemoryTokenStore()
+
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, store, time.Second)
+	}()
+	firstToken, err := HandshakeClient(clientConn, "user", "secret", time.Second)
+	if err != nil {
+		t.Fatalf("initial handshake: %v", err)
+	}
+	serverConn.Close()
+	clientConn.Close()
+
+	serverConn, clientConn = net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	serverTokens := make(chan string, 1)
+	serverResumedFrom := make(chan string, 1)
+	go func() {
+		_, token, resumedFrom, _ := HandshakeServer(serverConn, serverAuth, store, time.Second)
+		serverTokens <- token
+		serverResumedFrom <- resumedFrom
+	}()
+
+	secondToken, err := ResumeClient(clientConn, firstToken, time.Second)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if secondToken == "" || secondToken == firstToken {
+		t.Fatalf("expected a fresh rotated token, got %q (was %q)", secondToken, firstToken)
+	}
+	if got := <-serverTokens; got != secondToken {
+		t.Fatalf("expected server to report the rotated token, got %q", got)
+	}
+	if got := <-serverResumedFrom; got != firstToken {
+		t.Fatalf("expected server to report resumedFrom %q, got %q", firstToken, got)
+	}
+	if _, ok := store.Validate(firstToken); ok {
+		t.Fatalf("expected old token to be invalidated after rotation")
+	}
+}
+
+func TestHandshakeServerReportsNoResumeOnFreshLogin(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+	store := NewMemoryTokenStore()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	resumedFrom := make(chan string, 1)
+	go func() {
+		_, _, from, _ := HandshakeServer(serverConn, serverAuth, store, time.Second)
+		resumedFrom <- from
+	}()
+
+	if _, err := HandshakeClient(clientConn, "user", "secret", time.Second); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if got := <-resumedFrom; got != "" {
+		t.Fatalf("expected no resumedFrom on a fresh login, got %q", got)
+	}
+}
+
+func TestResumeClientRejectsUnknownToken(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+	store := NewMemoryTokenStore()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, store, time.Second)
+	}()
+
+	if _, err := ResumeClient(clientConn, "not-a-real-token", time.Second); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected auth failure for unknown token, got %v", err)
+	}
+}
+
+func TestHandshakeServerRejectsResumeWithoutStore(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go func() {
+		_, _, _, _ = HandshakeServer(serverConn, serverAuth, nil, time.Second)
+	}()
+
+	if _, err := ResumeClient(clientConn, "whatever", time.Second); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected auth failure when the server has no token store, got %v", err)
+	}
+}
+
+func TestHandshakeServerRejectsMismatchedVersion(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	serverAuth := NewBcryptAuth(hash)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		_, _, _, err := HandshakeServer(serverConn, serverAuth, nil, time.Second)
+		serverErr <- err
+	}()
+
+	payload := append([]byte{protocol.Version + 1}, encodeAuthPayload("ignored-user", "secret")...)
+	frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAuth, Payload: payload})
+	if err != nil {
+		t.Fatalf("encode auth message: %v", err)
+	}
+	if _, err := clientConn.Write(frame); err != nil {
+		t.Fatalf("write auth message: %v", err)
+	}
+	if _, err := protocol.Decode(clientConn); err != nil {
+		t.Fatalf("read auth response: %v", err)
+	}
+
+	if err := <-serverErr; !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected auth failure on version mismatch, got %v", err)
+	}
+}
+
 func TestGenerateToken(t *testing.T) {
 	var calls int
 	fakeRand := func(b []byte) (int, error) {
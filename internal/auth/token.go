@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"sync"
+	"time"
+)
+
+// defaultTokenTTL is how long a session token remains valid for resumption
+// before the client must re-authenticate with a password.
+const defaultTokenTTL = 10 * time.Minute
+
+// tokenLength is the number of random bytes backing a session token before
+// hex encoding (see GenerateToken).
+const tokenLength = 16
+
+// TokenInfo describes the session bound to a resumption token.
+type TokenInfo struct {
+	Username string
+	Expiry   time.Time
+	// LastStreamOffsets records, per multiplexed stream id (or
+	// protocol.ControlStream for single-stream mode), how many bytes had
+	// been transferred as of the last time the bound session was torn
+	// down. It is best-effort bookkeeping for observability; the actual
+	// byte replay on resume is driven by the live ring buffers the tunnel
+	// package retains alongside the token, not by this snapshot.
+	LastStreamOffsets map[uint32]uint64
+}
+
+// TokenStore issues and validates resumption tokens so a reconnecting client
+// can skip password authentication after a transient tunnel drop. The
+// in-memory implementation below is the default; a future Redis or BoltDB
+// backend can implement the same interface to share tokens across multiple
+// tunnel server instances.
+type TokenStore interface {
+	// Put records info under token, replacing any previous entry.
+	Put(token string, info TokenInfo)
+	// Validate looks up token and returns its info if present and not
+	// expired. Comparison against stored tokens is constant-time.
+	Validate(token string) (TokenInfo, bool)
+	// Rotate replaces token with newToken, carrying over its info with a
+	// refreshed expiry. It reports false if token is not currently valid.
+	Rotate(token, newToken string, ttl time.Duration) (TokenInfo, bool)
+	// Delete removes token, e.g. once the client has cleanly disconnected.
+	Delete(token string)
+	// SetOffsets records the current LastStreamOffsets for token, e.g. when
+	// its session is torn down. It is a no-op if token is not present.
+	SetOffsets(token string, offsets map[uint32]uint64)
+}
+
+// memoryTokenStore is the default in-process TokenStore. Tokens do not
+// survive a server restart.
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]TokenInfo
+}
+
+// NewMemoryTokenStore constructs an in-memory TokenStore.
+func NewMemoryTokenStore() TokenStore {
+	return &memoryTokenStore{tokens: make(map[string]TokenInfo)}
+}
+
+func (m *memoryTokenStore) Put(token string, info TokenInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[token] = info
+}
+
+func (m *memoryTokenStore) Validate(token string) (TokenInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, info, ok := m.lookup(token)
+	if !ok {
+		return TokenInfo{}, false
+	}
+	if time.Now().After(info.Expiry) {
+		delete(m.tokens, stored)
+		return TokenInfo{}, false
+	}
+	return info, true
+}
+
+func (m *memoryTokenStore) Rotate(token, newToken string, ttl time.Duration) (TokenInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, info, ok := m.lookup(token)
+	if !ok || time.Now().After(info.Expiry) {
+		return TokenInfo{}, false
+	}
+	delete(m.tokens, stored)
+	info.Expiry = time.Now().Add(ttl)
+	m.tokens[newToken] = info
+	return info, true
+}
+
+func (m *memoryTokenStore) Delete(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, _, ok := m.lookup(token); ok {
+		delete(m.tokens, stored)
+	}
+}
+
+func (m *memoryTokenStore) SetOffsets(token string, offsets map[uint32]uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, info, ok := m.lookup(token)
+	if !ok {
+		return
+	}
+	info.LastStreamOffsets = offsets
+	m.tokens[stored] = info
+}
+
+// lookup scans the token map comparing each candidate in constant time, so
+// the time to reject a guessed token doesn't vary with how close it is to a
+// real one. Callers must hold m.mu.
+func (m *memoryTokenStore) lookup(token string) (stored string, info TokenInfo, ok bool) {
+	want := []byte(token)
+	for candidate, candidateInfo := range m.tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), want) == 1 {
+			stored, info, ok = candidate, candidateInfo, true
+		}
+	}
+	return stored, info, ok
+}
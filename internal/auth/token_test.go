@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenStorePutAndValidate(t *testing.T) {
+	store := NewMemoryTokenStore()
+	store.Put("tok1", TokenInfo{Username: "alice", Expiry: time.Now().Add(time.Minute)})
+
+	info, ok := store.Validate("tok1")
+	if !ok {
+		t.Fatalf("expected token to validate")
+	}
+	if info.Username != "alice" {
+		t.Fatalf("expected username alice, got %q", info.Username)
+	}
+
+	if _, ok := store.Validate("tok2"); ok {
+		t.Fatalf("expected unknown token to fail validation")
+	}
+}
+
+func TestMemoryTokenStoreExpiry(t *testing.T) {
+	store := NewMemoryTokenStore()
+	store.Put("expired", TokenInfo{Username: "alice", Expiry: time.Now().Add(-time.Second)})
+
+	if _, ok := store.Validate("expired"); ok {
+		t.Fatalf("expected expired token to fail validation")
+	}
+	// A second validation should also fail (and not panic) now that the
+	// entry has been evicted.
+	if _, ok := store.Validate("expired"); ok {
+		t.Fatalf("expected expired token to remain invalid after eviction")
+	}
+}
+
+func TestMemoryTokenStoreRotate(t *testing.T) {
+	store := NewMemoryTokenStore()
+	store.Put("old", TokenInfo{Username: "bob", Expiry: time.Now().Add(time.Minute)})
+
+	info, ok := store.Rotate("old", "new", time.Minute)
+	if !ok {
+		t.Fatalf("expected rotate to succeed")
+	}
+	if info.Username != "bob" {
+		t.Fatalf("expected rotated info to carry over username, got %q", info.Username)
+	}
+
+	if _, ok := store.Validate("old"); ok {
+		t.Fatalf("expected old token to be invalidated")
+	}
+	if _, ok := store.Validate("new"); !ok {
+		t.Fatalf("expected new token to validate")
+	}
+
+	if _, ok := store.Rotate("old", "another", time.Minute); ok {
+		t.Fatalf("expected rotating a stale token to fail")
+	}
+}
+
+func TestMemoryTokenStoreDelete(t *testing.T) {
+	store := NewMemoryTokenStore()
+	store.Put("tok", TokenInfo{Username: "carol", Expiry: time.Now().Add(time.Minute)})
+
+	store.Delete("tok")
+	if _, ok := store.Validate("tok"); ok {
+		t.Fatalf("expected deleted token to fail validation")
+	}
+
+	// Deleting an already-absent token must be a no-op, not a panic.
+	store.Delete("tok")
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"time"
+)
+
+const defaultCommandTimeout = 5 * time.Second
+
+// commandAuth authenticates by invoking an external command with the
+// presented credentials as JSON on stdin and expecting a JSON verdict on
+// stdout, similar in spirit to sftpgo's external auth hook. It lets falcon
+// delegate to an existing user database (LDAP, an internal SSO, ...)
+// without the tunnel needing to speak that system's protocol directly.
+//
+// Two query parameters tune what's sent and how often the hook is invoked:
+//
+//	scope      comma-separated list of password, ip, fingerprint, sni
+//	           selecting which AuthRequest fields to forward; defaults to
+//	           password only. See ExternalAuthScope.
+//	cache_ttl  when set, caches a successful or failed verdict for this long
+//	           per distinct request, so a reconnecting client doesn't
+//	           re-invoke the hook on every FTP control connection.
+type commandAuth struct {
+	path    string
+	args    []string
+	timeout time.Duration
+	scope   ExternalAuthScope
+}
+
+func newCommandUserProvider(u *url.URL) (Authenticator, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("cmd auth requires a command path")
+	}
+	q := u.Query()
+
+	timeout := defaultCommandTimeout
+	if t := q.Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("parse cmd auth timeout: %w", err)
+		}
+		timeout = d
+	}
+
+	scope, err := parseExternalAuthScope(q.Get("scope"))
+	if err != nil {
+		return nil, fmt.Errorf("cmd auth: %w", err)
+	}
+
+	a := &commandAuth{path: u.Path, args: q["arg"], timeout: timeout, scope: scope}
+	return wrapWithCache(a, q.Get("cache_ttl"), scope)
+}
+
+// wrapWithCache wraps a into a cachingAuth when ttl (a duration string) is
+// non-empty, otherwise returns a unchanged. scope is the same
+// ExternalAuthScope a was constructed with, so the cache is keyed only on
+// the fields a actually forwards to its hook.
+func wrapWithCache(a Authenticator, ttl string, scope ExternalAuthScope) (Authenticator, error) {
+	if ttl == "" {
+		return a, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return nil, fmt.Errorf("parse cache_ttl: %w", err)
+	}
+	return newCachingAuth(a, d, scope), nil
+}
+
+func (a *commandAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	payload, err := json.Marshal(buildExternalAuthPayload(req, a.scope))
+	if err != nil {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, a.path, a.args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+
+	var verdict externalAuthVerdict
+	if err := json.Unmarshal(out, &verdict); err != nil || !verdict.Allow {
+		return nil, false
+	}
+
+	principal, err := verdict.principal(req)
+	if err != nil {
+		return nil, false
+	}
+	return principal, true
+}
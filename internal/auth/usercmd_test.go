@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeFakeAuthHook writes a tiny shell script standing in for an external
+// auth command: it reads the JSON request off stdin (ignored) and echoes
+// back a fixed JSON verdict, so tests don't need a real LDAP/SSO backend.
+func writeFakeAuthHook(t *testing.T, verdict string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake auth hook script requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hook.sh")
+	script := fmt.Sprintf("#!/bin/sh\ncat >/dev/null\ncat <<'EOF'\n%s\nEOF\n", verdict)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("write hook script: %v", err)
+	}
+	return path
+}
+
+func TestCommandAuthAllow(t *testing.T) {
+	hook := writeFakeAuthHook(t, `{"allow":true,"roles":["proxy"],"attributes":{"dept":"ops"}}`)
+
+	a, err := NewAuth("cmd://" + hook)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	p, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"})
+	if !ok {
+		t.Fatalf("expected hook to authenticate")
+	}
+	if !p.HasRole("proxy") {
+		t.Fatalf("expected proxy role, got %v", p.Roles)
+	}
+	if p.Attributes["dept"] != "ops" {
+		t.Fatalf("expected dept attribute, got %v", p.Attributes)
+	}
+}
+
+func TestCommandAuthDeny(t *testing.T) {
+	hook := writeFakeAuthHook(t, `{"allow":false}`)
+
+	a, err := NewAuth("cmd://" + hook)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"}); ok {
+		t.Fatalf("expected hook denial to fail authentication")
+	}
+}
+
+func TestCommandAuthRequiresPath(t *testing.T) {
+	if _, err := NewAuth("cmd://"); err == nil {
+		t.Fatalf("expected error for cmd auth without a command path")
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User describes one configured account: its credential and the limits and
+// roles that should flow into the Principal returned on a successful
+// authentication. It mirrors config.UserConfig, but auth can't import
+// config (config already imports auth for HashPassword), so callers convert
+// at the boundary; see tunnel.buildAuthenticator.
+type User struct {
+	Username       string
+	PasswordHash   string
+	AllowedCIDRs   []string
+	IdleTimeout    time.Duration
+	MaxConnections int
+	Roles          []string
+}
+
+// userListAuth authenticates against a fixed, in-memory list of users — the
+// "current behavior" of listing accounts directly in the YAML config, as
+// opposed to a jsonfile:// or cmd:// backend that looks them up elsewhere.
+type userListAuth struct {
+	users map[string]User
+}
+
+// NewUserListAuth builds an Authenticator backed by a fixed list of users,
+// e.g. config.AuthConfig.Users. Later entries with a duplicate username
+// shadow earlier ones.
+func NewUserListAuth(users []User) Authenticator {
+	m := make(map[string]User, len(users))
+	for _, u := range users {
+		m[u.Username] = u
+	}
+	return &userListAuth{users: m}
+}
+
+func (a *userListAuth) Authenticate(req AuthRequest) (*Principal, bool) {
+	u, ok := a.users[req.Username]
+	if !ok {
+		return nil, false
+	}
+	return authenticateUser(u, req)
+}
+
+// authenticateUser checks password and source CIDR against a resolved User
+// record and, on success, builds the Principal carrying its limits. Shared
+// by the list and jsonfile backends; cmd:// and http(s):// build their
+// Principal from the hook's verdict instead (see externalAuthVerdict).
+func authenticateUser(u User, req AuthRequest) (*Principal, bool) {
+	if u.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+		return nil, false
+	}
+	if !sourceAllowed(u.AllowedCIDRs, req.RemoteAddr) {
+		return nil, false
+	}
+	return &Principal{
+		Username:       u.Username,
+		Roles:          u.Roles,
+		AllowedCIDRs:   u.AllowedCIDRs,
+		IdleTimeout:    u.IdleTimeout,
+		MaxConnections: u.MaxConnections,
+	}, true
+}
+
+// sourceAllowed reports whether remote's IP falls within one of cidrs, or
+// true if cidrs is empty (no restriction configured). remote.String() is
+// read as "host:port" or a bare IP; a nil remote, an address that doesn't
+// parse as an IP, or a malformed CIDR entry is treated as not matching.
+func sourceAllowed(cidrs []string, remote net.Addr) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	if remote == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		host = remote.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
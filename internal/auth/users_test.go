@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUserListAuth(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	a := NewUserListAuth([]User{
+		{Username: "alice", PasswordHash: hash, Roles: []string{"admin"}, MaxConnections: 2},
+	})
+
+	p, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"})
+	if !ok {
+		t.Fatalf("expected alice to authenticate")
+	}
+	if !p.HasRole("admin") {
+		t.Fatalf("expected resolved principal to carry the admin role, got %v", p.Roles)
+	}
+	if p.MaxConnections != 2 {
+		t.Fatalf("expected MaxConnections 2, got %d", p.MaxConnections)
+	}
+
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "bob", Password: "secret"}); ok {
+		t.Fatalf("expected unknown user to fail")
+	}
+}
+
+func TestUserListAuthAllowedCIDRs(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	a := NewUserListAuth([]User{
+		{Username: "alice", PasswordHash: hash, AllowedCIDRs: []string{"10.0.0.0/8"}},
+	})
+
+	allowed := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5555}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret", RemoteAddr: allowed}); !ok {
+		t.Fatalf("expected a source inside the allowed CIDR to authenticate")
+	}
+
+	denied := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5555}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret", RemoteAddr: denied}); ok {
+		t.Fatalf("expected a source outside the allowed CIDR to fail")
+	}
+}
+
+func TestUserListAuthIdleTimeoutOverride(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	a := NewUserListAuth([]User{
+		{Username: "alice", PasswordHash: hash, IdleTimeout: 30 * time.Second},
+	})
+
+	p, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"})
+	if !ok {
+		t.Fatalf("expected alice to authenticate")
+	}
+	if p.IdleTimeout != 30*time.Second {
+		t.Fatalf("expected resolved IdleTimeout override, got %v", p.IdleTimeout)
+	}
+}
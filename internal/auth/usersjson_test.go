@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONFileAuth(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.json")
+	body := `[{"username":"alice","password_hash":"` + hash + `","roles":["admin"],"idle_timeout":"45s"}]`
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write users.json: %v", err)
+	}
+
+	a, err := NewAuth("jsonfile://" + path + "?reload=0")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	p, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "secret"})
+	if !ok {
+		t.Fatalf("expected alice to authenticate")
+	}
+	if !p.HasRole("admin") {
+		t.Fatalf("expected admin role, got %v", p.Roles)
+	}
+	if _, ok := a.Authenticate(AuthRequest{Username: "alice", Password: "wrong"}); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+}
+
+func TestJSONFileAuthReload(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.json")
+	if err := os.WriteFile(path, []byte(`[{"username":"alice","password_hash":"`+hash+`"}]`), 0o644); err != nil {
+		t.Fatalf("write users.json: %v", err)
+	}
+
+	a, err := NewAuth("jsonfile://" + path + "?reload=0")
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	jf := a.(*jsonFileAuth)
+
+	if err := os.WriteFile(path, []byte(`[{"username":"alice","password_hash":"`+hash+`"},{"username":"bob","password_hash":"`+hash+`"}]`), 0o644); err != nil {
+		t.Fatalf("rewrite users.json: %v", err)
+	}
+	if err := jf.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := jf.Authenticate(AuthRequest{Username: "bob", Password: "secret"}); !ok {
+		t.Fatalf("expected newly added user to authenticate after reload")
+	}
+}
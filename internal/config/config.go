@@ -4,6 +4,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -18,6 +19,23 @@ type Config struct {
 	Auth   AuthConfig   `yaml:"auth"`
 	TLS    TLSConfig    `yaml:"tls"`
 	Log    LogConfig    `yaml:"log"`
+
+	// Notifiers configures connection lifecycle notifications delivered to
+	// external systems; see tunnel.BuildNotifiers. A non-empty list replaces
+	// the default log-line notifier rather than adding to it.
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// NotifierConfig describes one entry under the top-level notifiers list.
+type NotifierConfig struct {
+	// Type selects the notifier implementation. Currently only "webhook" is
+	// supported; see tunnel.BuildNotifiers.
+	Type string `yaml:"type"`
+	// URL is the webhook endpoint to POST JSON events to.
+	URL string `yaml:"url"`
+	// Secret, if set, HMAC-SHA256 signs each webhook body; see
+	// tunnel.WebhookNotifier.
+	Secret string `yaml:"secret"`
 }
 
 type ServerConfig struct {
@@ -25,24 +43,157 @@ type ServerConfig struct {
 	FTPServerAddr  string        `yaml:"ftp_server_addr"`
 	MaxConnections int           `yaml:"max_connections"`
 	Timeout        time.Duration `yaml:"timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	PoolSize       int           `yaml:"pool_size"`
+	Multiplex      bool          `yaml:"multiplex"` // accept many FTP sessions per tunnel connection
+
+	// FTPMode enables active/passive FTP data channel handling: PORT/EPRT
+	// commands are rewritten to a shadow listener on the server so the
+	// real backend server can reach the data connection, and the
+	// resulting transfer is carried over its own multiplexed stream. Only
+	// takes effect when Multiplex is also set, since data channels ride
+	// on the same Session as the control connection.
+	FTPMode bool `yaml:"ftp_mode"`
 }
 
 type ClientConfig struct {
-	TunnelAddr   string        `yaml:"tunnel_addr"`
-	LocalFTPPort int           `yaml:"local_ftp_port"`
-	Timeout      time.Duration `yaml:"timeout"`
-	Password     string        `yaml:"password"` // plaintext password to send when auth is enabled
+	TunnelAddr     string        `yaml:"tunnel_addr"`
+	LocalFTPPort   int           `yaml:"local_ftp_port"`
+	Timeout        time.Duration `yaml:"timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	KeepAlive      time.Duration `yaml:"keep_alive"`
+	MaxRetries     int           `yaml:"max_retries"`
+	BackoffInitial time.Duration `yaml:"backoff_initial"`
+	BackoffMax     time.Duration `yaml:"backoff_max"`
+	Username       string        `yaml:"username"`  // username to send when auth is enabled
+	Password       string        `yaml:"password"`  // plaintext password to send when auth is enabled
+	Multiplex      bool          `yaml:"multiplex"` // hold one tunnel connection open and open many FTP sessions over it
+
+	// FTPMode enables active/passive FTP data channel handling: PASV/EPSV
+	// replies are rewritten to a local shadow listener so the real FTP
+	// client connects back through the tunnel instead of dialing the
+	// backend directly, and the resulting transfer is carried over its
+	// own multiplexed stream. Only takes effect when Multiplex is also
+	// set, since data channels ride on the same Session as the control
+	// connection.
+	FTPMode bool `yaml:"ftp_mode"`
 }
 
 type AuthConfig struct {
-	Enabled      bool   `yaml:"enabled"`
-	PasswordHash string `yaml:"password_hash"`
+	Enabled      bool     `yaml:"enabled"`
+	PasswordHash string   `yaml:"password_hash"`
+	Backends     []string `yaml:"backends"` // auth backend specs, e.g. "static://?username=u&password=p"
+
+	// Users, when non-empty, replaces the single shared PasswordHash with a
+	// real per-user store: each entry carries its own credential, source
+	// restriction, limits, and role tags. It takes precedence over Backends
+	// and PasswordHash; see tunnel.buildAuthenticator.
+	Users []UserConfig `yaml:"users"`
+}
+
+// UserConfig describes one configured account under auth.users. Roles are
+// free-form tags ("admin", "proxy", ...); the tunnel server doesn't
+// interpret them itself, but threads them through to the resolved
+// auth.Principal for callers (and future authorization checks) to use.
+type UserConfig struct {
+	Username       string        `yaml:"username"`
+	PasswordHash   string        `yaml:"password_hash"`
+	AllowedCIDRs   []string      `yaml:"allowed_cidrs"`   // source IPs allowed to authenticate as this user; empty means any
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // per-user override of server.idle_timeout; zero uses the server default
+	MaxConnections int           `yaml:"max_connections"` // per-user concurrent connection cap; zero means unlimited
+	Roles          []string      `yaml:"roles"`
 }
 
 type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	CertFile string `yaml:"cert_file"`
-	KeyFile  string `yaml:"key_file"`
+	Enabled            bool   `yaml:"enabled"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CertFingerprint    string `yaml:"cert_fingerprint"`
+
+	// CertPins, when non-empty, pins one or more certificates in the
+	// presented chain by the SHA-256 hash of their SubjectPublicKeyInfo, in
+	// the HPKP-style "sha256/<base64>" form. Unlike CertFingerprint (which
+	// only matches the leaf's whole-certificate hash), a SPKI pin can target
+	// an intermediate or root, so a leaf renewal that keeps the same key (or
+	// is still signed by a pinned CA) doesn't break already-deployed
+	// clients. See config.ComputeSPKIPin and the falcon-tunnel-pin helper
+	// for generating pins from a live server.
+	CertPins []string `yaml:"cert_pins"`
+
+	// PinsFile, when set, holds one SHA-256 leaf fingerprint per line and is
+	// re-read on a watch interval so operators can rotate pins without
+	// restarting the client.
+	PinsFile string `yaml:"pins_file"`
+
+	// Reload, when true, watches CertFile/KeyFile/CAFile for changes and
+	// swaps in new material for future handshakes without dropping existing
+	// connections.
+	Reload         bool          `yaml:"reload"`
+	ReloadInterval time.Duration `yaml:"reload_interval"` // fallback mtime-poll period; fsnotify is used when available
+
+	// ClientCAFile, set on the server, is a PEM bundle of CAs trusted to sign
+	// client certificates for mutual TLS. Required when ClientAuth requests
+	// or requires a client certificate.
+	ClientCAFile string `yaml:"client_ca_file"`
+
+	// ClientAuth selects the server's mutual TLS posture: "none" (default,
+	// no client certificate requested), "request" (requested but not
+	// required; a presented certificate is not verified against
+	// ClientCAFile), or
+	// "require-and-verify" (the client must present a certificate signed by
+	// a CA in ClientCAFile). The verified certificate's identity then flows
+	// to auth.Authenticator via AuthRequest.TLS; see auth.certAuth.
+	ClientAuth string `yaml:"client_auth"`
+
+	// AllowedClientCNs, when non-empty, further restricts a verified client
+	// certificate to one whose CommonName appears in this list, on top of
+	// chain validation against ClientCAFile. This lets an operator trust a
+	// shared internal CA for issuance while still only letting specific
+	// identities through, without maintaining a separate auth backend.
+	AllowedClientCNs []string `yaml:"allowed_client_cns"`
+
+	// AllowedClientFingerprints, when non-empty, restricts a verified client
+	// certificate to one whose SHA-256 leaf fingerprint (hex, ':'-separated
+	// or not) appears in this list. Combine with AllowedClientCNs for
+	// belt-and-suspenders pinning, or use alone to pin exact certificates
+	// issued outside ClientCAFile's chain of trust.
+	AllowedClientFingerprints []string `yaml:"allowed_client_fingerprints"`
+
+	// VerifyPeerAddress, when true, rejects a client whose observed remote
+	// IP isn't covered by any IP SAN in its certificate (or, for a
+	// certificate with only DNS SANs, by reverse-DNS resolving the remote
+	// IP and matching a hostname), following etcd transport's "deny
+	// incoming peer certs with wrong IP SAN" fix. Checked by
+	// tunnel.Server.Serve after the TLS handshake completes; see
+	// VerifyPeerAddress.
+	VerifyPeerAddress bool `yaml:"verify_peer_address"`
+
+	// AllowedClientSANs, when non-empty, restricts which SAN entries
+	// (IP or DNS) a client certificate may rely on, regardless of what the
+	// issuing CA was willing to sign; a certificate must carry at least one
+	// SAN in this list to be accepted. Use alongside VerifyPeerAddress to
+	// also cap which addresses/hostnames are acceptable in the first place.
+	AllowedClientSANs []string `yaml:"allowed_client_sans"`
+
+	// AutoGenerate, when true, makes the server bootstrap a self-signed
+	// RSA-2048 certificate at CertFile/KeyFile on startup if neither file
+	// exists yet, instead of failing to start; see EnsureSelfSignedCert.
+	// An existing cert/key pair is never overwritten.
+	AutoGenerate bool `yaml:"auto_generate"`
+
+	// PKCS12File, when set, loads the certificate and private key from a
+	// single PKCS#12 (.p12/.pfx) bundle instead of separate PEM CertFile and
+	// KeyFile, matching how certs are commonly exported from Windows and
+	// Java keystores. Takes precedence over CertFile/KeyFile when both are
+	// set; see loadCertificate.
+	PKCS12File string `yaml:"pkcs12_file"`
+
+	// PKCS12Password decrypts PKCS12File. An empty password is valid for
+	// bundles exported without one.
+	PKCS12Password string `yaml:"pkcs12_password"`
 }
 
 type LogConfig struct {
@@ -76,10 +227,16 @@ func Default() Config {
 			ListenAddr:     ":8080",
 			MaxConnections: 100,
 			Timeout:        30 * time.Second,
+			IdleTimeout:    5 * time.Minute,
+			PoolSize:       10,
 		},
 		Client: ClientConfig{
-			LocalFTPPort: 2121,
-			Timeout:      30 * time.Second,
+			LocalFTPPort:   2121,
+			Timeout:        30 * time.Second,
+			IdleTimeout:    5 * time.Minute,
+			MaxRetries:     3,
+			BackoffInitial: 500 * time.Millisecond,
+			BackoffMax:     5 * time.Second,
 		},
 		Auth: AuthConfig{
 			Enabled:      false,
@@ -108,6 +265,12 @@ func ApplyDefaults(cfg *Config) {
 	if cfg.Server.Timeout == 0 {
 		cfg.Server.Timeout = defaults.Server.Timeout
 	}
+	if cfg.Server.IdleTimeout == 0 {
+		cfg.Server.IdleTimeout = defaults.Server.IdleTimeout
+	}
+	if cfg.Server.PoolSize == 0 {
+		cfg.Server.PoolSize = defaults.Server.PoolSize
+	}
 
 	if cfg.Client.LocalFTPPort == 0 {
 		cfg.Client.LocalFTPPort = defaults.Client.LocalFTPPort
@@ -115,6 +278,18 @@ func ApplyDefaults(cfg *Config) {
 	if cfg.Client.Timeout == 0 {
 		cfg.Client.Timeout = defaults.Client.Timeout
 	}
+	if cfg.Client.IdleTimeout == 0 {
+		cfg.Client.IdleTimeout = defaults.Client.IdleTimeout
+	}
+	if cfg.Client.MaxRetries == 0 {
+		cfg.Client.MaxRetries = defaults.Client.MaxRetries
+	}
+	if cfg.Client.BackoffInitial == 0 {
+		cfg.Client.BackoffInitial = defaults.Client.BackoffInitial
+	}
+	if cfg.Client.BackoffMax == 0 {
+		cfg.Client.BackoffMax = defaults.Client.BackoffMax
+	}
 
 	if cfg.Log.Level == "" {
 		cfg.Log.Level = defaults.Log.Level
@@ -126,16 +301,18 @@ func ApplyDefaults(cfg *Config) {
 
 // Validation errors for required fields.
 var (
-	ErrMissingServerListenAddr = errors.New("server.listen_addr is required")
-	ErrMissingFTPServerAddr    = errors.New("server.ftp_server_addr is required")
-	ErrMissingTunnelAddr       = errors.New("client.tunnel_addr is required")
-	ErrMissingLocalFTPPort     = errors.New("client.local_ftp_port must be > 0")
-	ErrMissingClientPassword   = errors.New("client.password is required when auth is enabled")
-	ErrMissingPasswordHash     = errors.New("auth.password_hash is required when auth is enabled")
-	ErrMissingTLSCert          = errors.New("tls.cert_file is required when TLS is enabled")
-	ErrMissingTLSKey           = errors.New("tls.key_file is required when TLS is enabled")
-	ErrInvalidMaxConnections   = errors.New("server.max_connections must be > 0")
-	ErrInvalidTimeout          = errors.New("timeout must be > 0")
+	ErrMissingServerListenAddr     = errors.New("server.listen_addr is required")
+	ErrMissingFTPServerAddr        = errors.New("server.ftp_server_addr is required")
+	ErrMissingTunnelAddr           = errors.New("client.tunnel_addr is required")
+	ErrMissingLocalFTPPort         = errors.New("client.local_ftp_port must be > 0")
+	ErrMissingClientPassword       = errors.New("client.password is required when auth is enabled")
+	ErrMissingPasswordHash         = errors.New("auth.password_hash is required when auth is enabled")
+	ErrMissingTLSCert              = errors.New("tls.cert_file is required when TLS is enabled")
+	ErrMissingTLSKey               = errors.New("tls.key_file is required when TLS is enabled")
+	ErrInvalidMaxConnections       = errors.New("server.max_connections must be > 0")
+	ErrInvalidTimeout              = errors.New("timeout must be > 0")
+	ErrFTPModeRequiresMultiplex    = errors.New("ftp_mode requires multiplex to be enabled")
+	ErrAllowlistRequiresClientAuth = errors.New("tls.allowed_client_cns/allowed_client_fingerprints require tls.client_auth to request a client certificate (or tls.client_ca_file to be set)")
 )
 
 // Validate checks required and minimal values.
@@ -161,13 +338,29 @@ func Validate(cfg *Config) error {
 	if cfg.Client.Timeout <= 0 {
 		return ErrInvalidTimeout
 	}
-	if cfg.Auth.Enabled && cfg.Auth.PasswordHash == "" {
+	if cfg.Auth.Enabled && cfg.Auth.PasswordHash == "" && len(cfg.Auth.Backends) == 0 && len(cfg.Auth.Users) == 0 {
 		return ErrMissingPasswordHash
 	}
-	if cfg.Auth.Enabled && cfg.Client.Password == "" {
+	// Backend-based and per-user auth (e.g. cert:// mTLS identity, or
+	// auth.users) may not need a client password at all, so this only
+	// applies to the legacy single-bcrypt-hash mode.
+	if cfg.Auth.Enabled && cfg.Auth.PasswordHash != "" && cfg.Client.Password == "" {
 		return ErrMissingClientPassword
 	}
-	if cfg.TLS.Enabled {
+	for i, u := range cfg.Auth.Users {
+		if u.Username == "" {
+			return fmt.Errorf("auth.users[%d]: username is required", i)
+		}
+		if u.PasswordHash == "" {
+			return fmt.Errorf("auth.users[%d]: password_hash is required", i)
+		}
+		for _, cidr := range u.AllowedCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("auth.users[%d]: invalid allowed_cidrs entry %q: %w", i, cidr, err)
+			}
+		}
+	}
+	if cfg.TLS.Enabled && cfg.TLS.PKCS12File == "" {
 		if cfg.TLS.CertFile == "" {
 			return ErrMissingTLSCert
 		}
@@ -175,6 +368,16 @@ func Validate(cfg *Config) error {
 			return ErrMissingTLSKey
 		}
 	}
+	if (len(cfg.TLS.AllowedClientCNs) > 0 || len(cfg.TLS.AllowedClientFingerprints) > 0) &&
+		cfg.TLS.ClientAuth != "request" && cfg.TLS.ClientAuth != "require-and-verify" && cfg.TLS.ClientCAFile == "" {
+		return ErrAllowlistRequiresClientAuth
+	}
+	if cfg.Server.FTPMode && !cfg.Server.Multiplex {
+		return ErrFTPModeRequiresMultiplex
+	}
+	if cfg.Client.FTPMode && !cfg.Client.Multiplex {
+		return ErrFTPModeRequiresMultiplex
+	}
 	return nil
 }
 
@@ -189,15 +392,31 @@ type Overrides struct {
 	LocalFTPPort   *int
 	ClientTimeout  *time.Duration
 	ClientPassword *string
-	AuthEnabled    *bool
-	Password       *string
-	PasswordHash   *string
-	TLSEnabled     *bool
-	TLSCertFile    *string
-	TLSKeyFile     *string
-	LogLevel       *string
-	LogFilePath    *string
-	LogFormat      *string
+
+	// ClientIdle, ClientKeepAlive, ClientRetries, ClientBackoffInitial and
+	// ClientBackoffMax override the client's idle timeout, TCP keepalive
+	// interval, and dial/auth retry pacing (see internal/retry and
+	// Client.dialWithRetry).
+	ClientIdle           *time.Duration
+	ClientKeepAlive      *time.Duration
+	ClientRetries        *int
+	ClientBackoffInitial *time.Duration
+	ClientBackoffMax     *time.Duration
+
+	// ServerIdle and PoolSize override the server's proxied-connection idle
+	// timeout and connPool size.
+	ServerIdle *time.Duration
+	PoolSize   *int
+
+	AuthEnabled  *bool
+	Password     *string
+	PasswordHash *string
+	TLSEnabled   *bool
+	TLSCertFile  *string
+	TLSKeyFile   *string
+	LogLevel     *string
+	LogFilePath  *string
+	LogFormat    *string
 }
 
 // ApplyOverrides mutates cfg using non-nil override values.
@@ -226,6 +445,27 @@ func ApplyOverrides(cfg *Config, o Overrides) error {
 	if o.ClientPassword != nil {
 		cfg.Client.Password = *o.ClientPassword
 	}
+	if o.ClientIdle != nil {
+		cfg.Client.IdleTimeout = *o.ClientIdle
+	}
+	if o.ClientKeepAlive != nil {
+		cfg.Client.KeepAlive = *o.ClientKeepAlive
+	}
+	if o.ClientRetries != nil {
+		cfg.Client.MaxRetries = *o.ClientRetries
+	}
+	if o.ClientBackoffInitial != nil {
+		cfg.Client.BackoffInitial = *o.ClientBackoffInitial
+	}
+	if o.ClientBackoffMax != nil {
+		cfg.Client.BackoffMax = *o.ClientBackoffMax
+	}
+	if o.ServerIdle != nil {
+		cfg.Server.IdleTimeout = *o.ServerIdle
+	}
+	if o.PoolSize != nil {
+		cfg.Server.PoolSize = *o.PoolSize
+	}
 	if o.AuthEnabled != nil {
 		cfg.Auth.Enabled = *o.AuthEnabled
 	}
@@ -312,6 +552,9 @@ type boolFlag struct {
 }
 
 func (f *boolFlag) String() string { return fmt.Sprintf("%t", f.value) }
+
+// Value reports the flag's current value, defaulting to false if unset.
+func (f *boolFlag) Value() bool { return f.value }
 func (f *boolFlag) Set(v string) error {
 	if v == "" {
 		f.value = true
@@ -344,6 +587,15 @@ type CLIFlags struct {
 	ClientTimeout  durationFlag
 	ClientPassword stringFlag
 
+	ClientIdle           durationFlag
+	ClientKeepAlive      durationFlag
+	ClientRetries        intFlag
+	ClientBackoffInitial durationFlag
+	ClientBackoffMax     durationFlag
+
+	ServerIdle durationFlag
+	PoolSize   intFlag
+
 	AuthEnabled  boolFlag
 	Password     stringFlag
 	PasswordHash stringFlag
@@ -352,6 +604,15 @@ type CLIFlags struct {
 	TLSCertFile stringFlag
 	TLSKeyFile  stringFlag
 
+	// GenerateCert, when set, tells main() to write a self-signed cert/key
+	// pair to TLSCertFile/TLSKeyFile and exit instead of starting the
+	// tunnel; see config.RunGenerateCert. The remaining GenerateCert* flags
+	// configure that certificate.
+	GenerateCert         boolFlag
+	GenerateCertHosts    stringFlag
+	GenerateCertKeyType  stringFlag
+	GenerateCertValidFor durationFlag
+
 	LogLevel    stringFlag
 	LogFilePath stringFlag
 	LogFormat   stringFlag
@@ -372,6 +633,14 @@ func RegisterFlags(fs *flag.FlagSet) *CLIFlags {
 	fs.Var(&flags.LocalFTPPort, "local-port", "Local FTP port to listen on")
 	fs.Var(&flags.ClientTimeout, "client-timeout", "Client timeout (e.g. 30s)")
 	fs.Var(&flags.ClientPassword, "client-password", "Plaintext password for client authentication")
+	fs.Var(&flags.ClientIdle, "client-idle", "Client idle timeout (e.g. 5m)")
+	fs.Var(&flags.ClientKeepAlive, "client-keepalive", "Client TCP keepalive interval (e.g. 30s)")
+	fs.Var(&flags.ClientRetries, "client-retries", "Maximum dial/auth retry attempts")
+	fs.Var(&flags.ClientBackoffInitial, "client-backoff-initial", "Initial retry backoff (e.g. 500ms)")
+	fs.Var(&flags.ClientBackoffMax, "client-backoff-max", "Maximum retry backoff (e.g. 5s)")
+
+	fs.Var(&flags.ServerIdle, "server-idle", "Server idle timeout for proxied connections (e.g. 5m)")
+	fs.Var(&flags.PoolSize, "pool-size", "Server FTP connection pool size")
 
 	fs.Var(&flags.AuthEnabled, "auth", "Enable authentication (true/false)")
 	fs.Var(&flags.Password, "password", "Plaintext password (hashed internally)")
@@ -381,6 +650,11 @@ func RegisterFlags(fs *flag.FlagSet) *CLIFlags {
 	fs.Var(&flags.TLSCertFile, "tls-cert", "TLS certificate file")
 	fs.Var(&flags.TLSKeyFile, "tls-key", "TLS private key file")
 
+	fs.Var(&flags.GenerateCert, "generate-cert", "Generate a self-signed cert/key pair at -tls-cert/-tls-key and exit")
+	fs.Var(&flags.GenerateCertHosts, "generate-cert-hosts", "Comma-separated DNS names/IPs for -generate-cert (default localhost)")
+	fs.Var(&flags.GenerateCertKeyType, "generate-cert-key-type", "Key type for -generate-cert: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519")
+	fs.Var(&flags.GenerateCertValidFor, "generate-cert-valid-for", "Certificate validity for -generate-cert (e.g. 8760h)")
+
 	fs.Var(&flags.LogLevel, "log-level", "Log level (debug, info, warn, error)")
 	fs.Var(&flags.LogFilePath, "log-file", "Log file path (optional)")
 	fs.Var(&flags.LogFormat, "log-format", "Log format: text or json")
@@ -419,6 +693,27 @@ func OverridesFromFlags(f *CLIFlags) Overrides {
 	if f.ClientPassword.set {
 		ov.ClientPassword = &f.ClientPassword.value
 	}
+	if f.ClientIdle.set {
+		ov.ClientIdle = &f.ClientIdle.value
+	}
+	if f.ClientKeepAlive.set {
+		ov.ClientKeepAlive = &f.ClientKeepAlive.value
+	}
+	if f.ClientRetries.set {
+		ov.ClientRetries = &f.ClientRetries.value
+	}
+	if f.ClientBackoffInitial.set {
+		ov.ClientBackoffInitial = &f.ClientBackoffInitial.value
+	}
+	if f.ClientBackoffMax.set {
+		ov.ClientBackoffMax = &f.ClientBackoffMax.value
+	}
+	if f.ServerIdle.set {
+		ov.ServerIdle = &f.ServerIdle.value
+	}
+	if f.PoolSize.set {
+		ov.PoolSize = &f.PoolSize.value
+	}
 	if f.AuthEnabled.set {
 		ov.AuthEnabled = &f.AuthEnabled.value
 	}
@@ -449,6 +744,48 @@ func OverridesFromFlags(f *CLIFlags) Overrides {
 	return ov
 }
 
+// RunGenerateCert writes a self-signed certificate and key using the
+// -generate-cert-* flags in f, to -tls-cert/-tls-key (defaulting to
+// cert.pem/key.pem if those weren't set). It's meant to be called by
+// main() right after flag parsing, before the normal Build/Validate
+// pipeline runs, so -generate-cert works even against an otherwise
+// incomplete configuration.
+func RunGenerateCert(f *CLIFlags) (certPath, keyPath string, err error) {
+	certPath = "cert.pem"
+	if f.TLSCertFile.set {
+		certPath = f.TLSCertFile.value
+	}
+	keyPath = "key.pem"
+	if f.TLSKeyFile.set {
+		keyPath = f.TLSKeyFile.value
+	}
+
+	hosts := "localhost"
+	if f.GenerateCertHosts.set {
+		hosts = f.GenerateCertHosts.value
+	}
+	keyType, err := ParseKeyType(f.GenerateCertKeyType.value)
+	if err != nil {
+		return "", "", err
+	}
+	validFor := 365 * 24 * time.Hour
+	if f.GenerateCertValidFor.set {
+		validFor = f.GenerateCertValidFor.value
+	}
+
+	cert, key, err := GenerateSelfSignedWithKeyType(hosts, validFor, keyType)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certPath, cert, 0o644); err != nil {
+		return "", "", fmt.Errorf("write cert file: %w", err)
+	}
+	if err := os.WriteFile(keyPath, key, 0o600); err != nil {
+		return "", "", fmt.Errorf("write key file: %w", err)
+	}
+	return certPath, keyPath, nil
+}
+
 // Build constructs a Config using defaults, optional file, and overrides.
 func Build(filePath string, overrides Overrides) (*Config, error) {
 	var cfg Config
@@ -123,6 +123,70 @@ func TestValidateFailures(t *testing.T) {
 	}
 }
 
+func TestValidateUsers(t *testing.T) {
+	cfg := Default()
+	cfg.Server.FTPServerAddr = "ftp:21"
+	cfg.Client.TunnelAddr = "server:8080"
+	cfg.Client.LocalFTPPort = 2021
+	cfg.Auth.Enabled = true
+	cfg.Auth.Users = []UserConfig{{Username: "alice", PasswordHash: "hash"}}
+
+	if err := Validate(&cfg); err != nil {
+		t.Fatalf("expected auth.users to satisfy auth validation without a client password, got %v", err)
+	}
+
+	cfg.Auth.Users[0].PasswordHash = ""
+	if err := Validate(&cfg); err == nil {
+		t.Fatalf("expected missing user password hash to fail validation")
+	}
+
+	cfg.Auth.Users[0].PasswordHash = "hash"
+	cfg.Auth.Users[0].AllowedCIDRs = []string{"not-a-cidr"}
+	if err := Validate(&cfg); err == nil {
+		t.Fatalf("expected invalid allowed_cidrs entry to fail validation")
+	}
+}
+
+func TestValidateTLSAllowsPKCS12InPlaceOfCertAndKeyFiles(t *testing.T) {
+	cfg := Default()
+	cfg.Server.FTPServerAddr = "ftp:21"
+	cfg.Client.TunnelAddr = "server:8080"
+	cfg.Client.LocalFTPPort = 2021
+	cfg.TLS.Enabled = true
+
+	if err := Validate(&cfg); err != ErrMissingTLSCert {
+		t.Fatalf("expected missing tls cert error, got %v", err)
+	}
+
+	cfg.TLS.PKCS12File = "bundle.p12"
+	if err := Validate(&cfg); err != nil {
+		t.Fatalf("expected pkcs12_file to satisfy tls validation without cert_file/key_file, got %v", err)
+	}
+}
+
+func TestValidateRequiresClientAuthForCertAllowlists(t *testing.T) {
+	cfg := Default()
+	cfg.Server.FTPServerAddr = "ftp:21"
+	cfg.Client.TunnelAddr = "server:8080"
+	cfg.Client.LocalFTPPort = 2021
+	cfg.TLS.AllowedClientCNs = []string{"alice"}
+
+	if err := Validate(&cfg); err != ErrAllowlistRequiresClientAuth {
+		t.Fatalf("expected allowlist without client_auth/client_ca_file to fail validation, got %v", err)
+	}
+
+	cfg.TLS.ClientAuth = "request"
+	if err := Validate(&cfg); err != nil {
+		t.Fatalf("expected client_auth=request to satisfy validation, got %v", err)
+	}
+
+	cfg.TLS.ClientAuth = ""
+	cfg.TLS.ClientCAFile = "ca.pem"
+	if err := Validate(&cfg); err != nil {
+		t.Fatalf("expected client_ca_file to satisfy validation, got %v", err)
+	}
+}
+
 func TestBuildWithFileAndOverrides(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "config.yaml")
@@ -2,12 +2,17 @@ package config
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -17,6 +22,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // ServerTLSConfig builds a tls.Config for servers using the provided TLSConfig.
@@ -24,17 +31,98 @@ func ServerTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 	if !cfg.Enabled {
 		return nil, nil
 	}
-	if cfg.CertFile == "" || cfg.KeyFile == "" {
-		return nil, fmt.Errorf("tls cert_file and key_file are required")
-	}
-	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	cert, err := loadCertificate(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("load key pair: %w", err)
+		return nil, err
 	}
-	return &tls.Config{
+	tlsCfg := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS12,
-	}, nil
+	}
+
+	clientAuth, err := parseClientAuthType(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("client ca file: %w", err)
+		}
+		tlsCfg.ClientCAs = pool
+		if clientAuth == tls.NoClientCert {
+			// A CA bundle was configured but client_auth wasn't, so default
+			// to actually enforcing it rather than silently ignoring it.
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	tlsCfg.ClientAuth = clientAuth
+
+	if len(cfg.AllowedClientCNs) > 0 || len(cfg.AllowedClientFingerprints) > 0 {
+		tlsCfg.VerifyPeerCertificate = verifyAllowedClientCert(cfg.AllowedClientCNs, cfg.AllowedClientFingerprints)
+	}
+
+	return tlsCfg, nil
+}
+
+// verifyAllowedClientCert returns a tls.Config.VerifyPeerCertificate callback
+// that rejects a client certificate whose CommonName isn't in allowedCNs
+// (when non-empty) or whose SHA-256 leaf fingerprint isn't in
+// allowedFingerprints (when non-empty). It runs after Go's own chain
+// verification against ClientCAs, narrowing "signed by a trusted CA" down to
+// "and is one of these specific identities".
+func verifyAllowedClientCert(allowedCNs, allowedFingerprints []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate presented")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("parse peer certificate: %w", err)
+		}
+		if len(allowedCNs) > 0 {
+			ok := false
+			for _, cn := range allowedCNs {
+				if leaf.Subject.CommonName == cn {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return fmt.Errorf("client certificate CN %q is not in allowed_client_cns", leaf.Subject.CommonName)
+			}
+		}
+		if len(allowedFingerprints) > 0 {
+			sum := sha256.Sum256(rawCerts[0])
+			got := hex.EncodeToString(sum[:])
+			ok := false
+			for _, want := range allowedFingerprints {
+				wantBytes, err := parseFingerprint(want)
+				if err == nil && bytes.Equal(wantBytes, sum[:]) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return fmt.Errorf("client certificate fingerprint %s is not in allowed_client_fingerprints", got)
+			}
+		}
+		return nil
+	}
+}
+
+// parseClientAuthType maps the client_auth config string to a tls.ClientAuthType.
+func parseClientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("tls.client_auth: unknown mode %q", mode)
+	}
 }
 
 // ClientTLSConfig builds a tls.Config for clients using the provided TLSConfig.
@@ -49,6 +137,16 @@ func ClientTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 		ServerName: cfg.ServerName,
 	}
 
+	if cfg.PKCS12File != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		// A client certificate to present for mutual TLS, verified by the
+		// server's ClientCAFile/ClientAuth; see config.ServerTLSConfig.
+		cert, err := loadCertificate(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("load client key pair: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
 	if cfg.CAFile != "" {
 		pool, err := loadCertPool(cfg.CAFile)
 		if err != nil {
@@ -57,11 +155,23 @@ func ClientTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 		tlsCfg.RootCAs = pool
 	}
 
-	if cfg.InsecureSkipVerify || cfg.CertFingerprint != "" {
+	if cfg.InsecureSkipVerify || cfg.CertFingerprint != "" || cfg.PinsFile != "" || len(cfg.CertPins) > 0 {
 		tlsCfg.InsecureSkipVerify = true
 	}
 
-	if cfg.CertFingerprint != "" {
+	if cfg.PinsFile != "" {
+		pins, err := NewReloadablePins(cfg.PinsFile, cfg.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.VerifyPeerCertificate = pins.VerifyPeerCertificate
+	} else if len(cfg.CertPins) > 0 {
+		verify, err := verifySPKIPins(cfg.CertPins)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.VerifyPeerCertificate = verify
+	} else if cfg.CertFingerprint != "" {
 		expect, err := parseFingerprint(cfg.CertFingerprint)
 		if err != nil {
 			return nil, err
@@ -81,6 +191,47 @@ func ClientTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 	return tlsCfg, nil
 }
 
+// loadCertificate loads the server/client certificate and key from cfg,
+// preferring a PKCS#12 bundle (cfg.PKCS12File) over the separate PEM
+// CertFile/KeyFile when both are configured.
+func loadCertificate(cfg TLSConfig) (tls.Certificate, error) {
+	if cfg.PKCS12File != "" {
+		return loadPKCS12KeyPair(cfg.PKCS12File, cfg.PKCS12Password)
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("tls cert_file and key_file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("load key pair: %w", err)
+	}
+	return cert, nil
+}
+
+// loadPKCS12KeyPair reads a PKCS#12 (.p12/.pfx) bundle from path, decrypting
+// it with password, and returns the leaf certificate and key as a
+// tls.Certificate, the same shape tls.LoadX509KeyPair produces for a PEM
+// pair.
+func loadPKCS12KeyPair(path, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("read pkcs12 file: %w", err)
+	}
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode pkcs12 file: %w", err)
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range caCerts {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
 func loadCertPool(path string) (*x509.CertPool, error) {
 	pool, err := x509.SystemCertPool()
 	if err != nil || pool == nil {
@@ -109,10 +260,200 @@ func parseFingerprint(fp string) ([]byte, error) {
 	return b, nil
 }
 
-// GenerateSelfSigned creates a self-signed certificate for the given host (IP or DNS).
-// It returns PEM-encoded cert and key bytes.
+// ComputeSPKIPin returns the HPKP-style "sha256/<base64>" pin for cert's
+// SubjectPublicKeyInfo, the same form accepted by TLSConfig.CertPins.
+func ComputeSPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parseSPKIPin decodes a "sha256/<base64>" pin string into its raw 32-byte
+// digest.
+func parseSPKIPin(pin string) ([]byte, error) {
+	const prefix = "sha256/"
+	if !strings.HasPrefix(pin, prefix) {
+		return nil, fmt.Errorf("cert pin %q must start with %q", pin, prefix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("decode cert pin %q: %w", pin, err)
+	}
+	if len(b) != sha256.Size {
+		return nil, fmt.Errorf("cert pin %q must decode to %d bytes", pin, sha256.Size)
+	}
+	return b, nil
+}
+
+// verifySPKIPins returns a tls.Config.VerifyPeerCertificate callback
+// accepting the presented chain if any certificate in it (leaf, or any
+// intermediate/root) has a SubjectPublicKeyInfo hash matching one of pins.
+// Pinning an intermediate or root, rather than only the leaf the way
+// CertFingerprint does, lets a certificate renewal that keeps the same
+// issuing chain go unnoticed by already-deployed clients.
+func verifySPKIPins(pins []string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	want := make([][]byte, 0, len(pins))
+	for _, p := range pins {
+		b, err := parseSPKIPin(p)
+		if err != nil {
+			return nil, err
+		}
+		want = append(want, b)
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate presented")
+		}
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, w := range want {
+				if bytes.Equal(sum[:], w) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no certificate in presented chain matches a configured cert pin")
+	}, nil
+}
+
+// VerifyPeerAddress checks that remoteAddr is covered by one of leaf's
+// Subject Alternative Names: directly, for an IP SAN equal to remoteAddr's
+// host; via reverse DNS, for a DNS SAN matching one of the hostnames
+// remoteAddr's IP resolves to. If allowedSANs is non-empty, leaf must also
+// carry at least one SAN (IP or DNS) in that list, regardless of what its
+// issuing CA was willing to sign. It closes the gap etcd's transport
+// package fixed: without it, any client holding a cert from a trusted CA
+// can dial in from anywhere, not just the host(s) the cert was issued for.
+func VerifyPeerAddress(leaf *x509.Certificate, remoteAddr net.Addr, allowedSANs []string) error {
+	if len(allowedSANs) > 0 && !certSANAllowed(leaf, allowedSANs) {
+		return fmt.Errorf("client certificate has no SAN in allowed_client_sans")
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		host = remoteAddr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("cannot parse remote address %q", remoteAddr.String())
+	}
+
+	for _, sanIP := range leaf.IPAddresses {
+		if sanIP.Equal(ip) {
+			return nil
+		}
+	}
+
+	if len(leaf.DNSNames) > 0 {
+		if names, err := net.LookupAddr(host); err == nil {
+			for _, n := range names {
+				n = strings.TrimSuffix(n, ".")
+				for _, san := range leaf.DNSNames {
+					if strings.EqualFold(n, san) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("remote address %s is not covered by any SAN in the client certificate", host)
+}
+
+// certSANAllowed reports whether any of leaf's IP or DNS SANs appears in
+// allowed.
+func certSANAllowed(leaf *x509.Certificate, allowed []string) bool {
+	allowedSet := make(map[string]struct{}, len(allowed))
+	for _, a := range allowed {
+		allowedSet[a] = struct{}{}
+	}
+	for _, ip := range leaf.IPAddresses {
+		if _, ok := allowedSet[ip.String()]; ok {
+			return true
+		}
+	}
+	for _, dns := range leaf.DNSNames {
+		if _, ok := allowedSet[dns]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyType selects the private key algorithm GenerateSelfSignedWithKeyType
+// generates. The zero value is not valid; use one of the KeyType constants.
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA4096   KeyType = "rsa4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+// ParseKeyType maps a config/flag string (e.g. "rsa4096") to a KeyType. The
+// empty string defaults to KeyTypeRSA2048, matching GenerateSelfSigned's
+// historical behavior.
+func ParseKeyType(s string) (KeyType, error) {
+	switch KeyType(s) {
+	case "":
+		return KeyTypeRSA2048, nil
+	case KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519:
+		return KeyType(s), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %q", s)
+	}
+}
+
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+}
+
+// encodePrivateKey PEM-encodes priv using the conventional block type for
+// its algorithm: PKCS#1 "RSA PRIVATE KEY" for RSA (for compatibility with
+// tools that don't understand PKCS#8), and PKCS#8 "PRIVATE KEY" for
+// everything else, since x509 has no SEC1-equivalent marshaler for Ed25519.
+func encodePrivateKey(priv crypto.Signer) (*pem.Block, error) {
+	if rsaKey, ok := priv.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// GenerateSelfSigned creates an RSA-2048 self-signed certificate for the
+// given host (IP or DNS). It returns PEM-encoded cert and key bytes; see
+// GenerateSelfSignedWithKeyType for other key algorithms.
 func GenerateSelfSigned(host string, validFor time.Duration) ([]byte, []byte, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	return GenerateSelfSignedWithKeyType(host, validFor, KeyTypeRSA2048)
+}
+
+// GenerateSelfSignedWithKeyType creates a self-signed certificate for the
+// given host (IP or DNS, comma-separated for multiple SANs) using the
+// requested key algorithm. It returns PEM-encoded cert and key bytes.
+func GenerateSelfSignedWithKeyType(host string, validFor time.Duration, keyType KeyType) ([]byte, []byte, error) {
+	priv, err := generateKey(keyType)
 	if err != nil {
 		return nil, nil, fmt.Errorf("generate key: %w", err)
 	}
@@ -126,6 +467,13 @@ func GenerateSelfSigned(host string, validFor time.Duration) ([]byte, []byte, er
 		return nil, nil, fmt.Errorf("serial number: %w", err)
 	}
 
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := priv.(*rsa.PrivateKey); ok {
+		// Only RSA keys can be used for key encipherment; ECDSA and Ed25519
+		// are signature-only.
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
@@ -133,7 +481,7 @@ func GenerateSelfSigned(host string, validFor time.Duration) ([]byte, []byte, er
 		},
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
-		KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:  keyUsage,
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
 			x509.ExtKeyUsageClientAuth,
@@ -151,19 +499,117 @@ func GenerateSelfSigned(host string, validFor time.Duration) ([]byte, []byte, er
 		}
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 	if err != nil {
 		return nil, nil, fmt.Errorf("create certificate: %w", err)
 	}
 
+	keyBlock, err := encodePrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	certBuf := &bytes.Buffer{}
 	keyBuf := &bytes.Buffer{}
 	if err := pem.Encode(certBuf, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
 		return nil, nil, fmt.Errorf("encode cert: %w", err)
 	}
-	if err := pem.Encode(keyBuf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}); err != nil {
+	if err := pem.Encode(keyBuf, keyBlock); err != nil {
 		return nil, nil, fmt.Errorf("encode key: %w", err)
 	}
 
 	return certBuf.Bytes(), keyBuf.Bytes(), nil
 }
+
+// GenerateSelfSignedPKCS12 creates an RSA-2048 self-signed certificate for
+// the given host (IP or DNS, comma-separated for multiple SANs), the same
+// way GenerateSelfSigned does, and returns it as a single password-protected
+// PKCS#12 (.p12) blob instead of separate PEM cert/key bytes.
+func GenerateSelfSignedPKCS12(host string, validFor time.Duration, password string) ([]byte, error) {
+	certPEM, keyPEM, err := GenerateSelfSigned(host, validFor)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSelfSignedPKCS12(certPEM, keyPEM, password)
+}
+
+// EncodeSelfSignedPKCS12 packages an already-generated PEM cert/key pair
+// (as returned by GenerateSelfSigned or GenerateSelfSignedWithKeyType) into
+// a password-protected PKCS#12 (.p12) blob, so callers that need both a PEM
+// pair and a .p12 bundle for the same identity don't have to generate the
+// cert twice. keyPEM may hold either block type encodePrivateKey produces.
+func EncodeSelfSignedPKCS12(certPEM, keyPEM []byte, password string) ([]byte, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("decode certificate: pem block not found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("decode key: pem block not found")
+	}
+	key, err := parsePrivateKeyBlock(keyBlock)
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	p12, err := pkcs12.Encode(rand.Reader, key, cert, nil, password)
+	if err != nil {
+		return nil, fmt.Errorf("encode pkcs12: %w", err)
+	}
+	return p12, nil
+}
+
+// parsePrivateKeyBlock reverses encodePrivateKey: PKCS#1 "RSA PRIVATE KEY"
+// for RSA, PKCS#8 "PRIVATE KEY" for everything else.
+func parsePrivateKeyBlock(block *pem.Block) (crypto.Signer, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key type %T does not implement crypto.Signer", key)
+	}
+	return signer, nil
+}
+
+// EnsureSelfSignedCert generates and writes a self-signed certificate to
+// cfg.CertFile/cfg.KeyFile if cfg.AutoGenerate is set and both files are
+// currently missing. It leaves existing files untouched even if
+// AutoGenerate is set, so operators can still supply a real CA-issued
+// certificate without disabling the flag. host defaults to cfg.ServerName,
+// falling back to "localhost" if that's empty too.
+func EnsureSelfSignedCert(cfg TLSConfig) error {
+	if !cfg.AutoGenerate || cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(cfg.CertFile); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(cfg.KeyFile); err == nil {
+		return nil
+	}
+
+	host := cfg.ServerName
+	if host == "" {
+		host = "localhost"
+	}
+	cert, key, err := GenerateSelfSigned(host, 365*24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("generate self-signed cert: %w", err)
+	}
+	if err := os.WriteFile(cfg.CertFile, cert, 0o644); err != nil {
+		return fmt.Errorf("write cert file: %w", err)
+	}
+	if err := os.WriteFile(cfg.KeyFile, key, 0o600); err != nil {
+		return fmt.Errorf("write key file: %w", err)
+	}
+	return nil
+}
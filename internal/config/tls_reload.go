@@ -0,0 +1,371 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultTLSReloadInterval = 30 * time.Second
+
+// ReloadableTLS holds server TLS material behind an atomic pointer and
+// refreshes it in the background, so rotating a cert (e.g. produced by
+// cmd/tlsgen) no longer requires bouncing the server or dropping live
+// tunnels. Existing connections keep the material they negotiated with;
+// only new handshakes observe a swap.
+type ReloadableTLS struct {
+	cfg    TLSConfig
+	logger *logrus.Logger
+
+	material atomic.Pointer[tlsMaterial]
+	stop     chan struct{}
+}
+
+type tlsMaterial struct {
+	cert *tls.Certificate
+	// clientCAPool, loaded from ClientCAFile, is the server's trust root for
+	// verifying client certificates (mutual TLS); see getConfigForClient.
+	clientCAPool *x509.CertPool
+	// rootCAPool, loaded from CAFile, is the client's trust root for
+	// verifying the server's certificate; see ClientConfig/verifyServerCert.
+	rootCAPool *x509.CertPool
+}
+
+// NewReloadableTLS loads the initial cert/key (and CA pool, if configured)
+// and starts a background watcher that re-reads them on change.
+func NewReloadableTLS(cfg TLSConfig, logger *logrus.Logger) (*ReloadableTLS, error) {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	r := &ReloadableTLS{cfg: cfg, logger: logger, stop: make(chan struct{})}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// Config returns a *tls.Config whose certificate (and client CA pool, if
+// any) are resolved on every handshake from the atomically-swapped material.
+func (r *ReloadableTLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     r.getCertificate,
+		GetConfigForClient: r.getConfigForClient,
+	}
+}
+
+func (r *ReloadableTLS) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m := r.material.Load()
+	if m == nil || m.cert == nil {
+		return nil, fmt.Errorf("tls: no certificate loaded")
+	}
+	return m.cert, nil
+}
+
+func (r *ReloadableTLS) getConfigForClient(info *tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, err := r.getCertificate(info)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	clientAuth, err := parseClientAuthType(r.cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if m := r.material.Load(); m != nil && m.clientCAPool != nil {
+		cfg.ClientCAs = m.clientCAPool
+		if clientAuth == tls.NoClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	cfg.ClientAuth = clientAuth
+	if len(r.cfg.AllowedClientCNs) > 0 || len(r.cfg.AllowedClientFingerprints) > 0 {
+		cfg.VerifyPeerCertificate = verifyAllowedClientCert(r.cfg.AllowedClientCNs, r.cfg.AllowedClientFingerprints)
+	}
+	return cfg, nil
+}
+
+// ClientConfig returns a *tls.Config for dialing out as a client, whose
+// presented certificate (for mutual TLS) and trusted root CA are resolved on
+// every handshake from the atomically-swapped material, the client-side
+// counterpart to Config. When CAFile isn't set, server certificate
+// verification falls back to the default system root pool, which needs no
+// hot-reload support of its own.
+func (r *ReloadableTLS) ClientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           r.cfg.ServerName,
+		GetClientCertificate: r.getClientCertificate,
+	}
+	if r.cfg.CAFile != "" {
+		// tls.Config.RootCAs is read once per handshake but can't be swapped
+		// out from under a *static* pointer the way GetCertificate lets the
+		// server do; InsecureSkipVerify plus a manual VerifyPeerCertificate
+		// is the documented way to defer verification until the current
+		// pool is known.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = r.verifyServerCert
+	}
+	return cfg
+}
+
+func (r *ReloadableTLS) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m := r.material.Load()
+	if m == nil || m.cert == nil {
+		return nil, fmt.Errorf("tls: no client certificate loaded")
+	}
+	return m.cert, nil
+}
+
+// verifyServerCert implements tls.Config.VerifyPeerCertificate, verifying
+// the presented chain against the currently-loaded root CA pool and, if
+// ServerName is set, its hostname.
+func (r *ReloadableTLS) verifyServerCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	m := r.material.Load()
+	if m == nil || m.rootCAPool == nil {
+		return errors.New("tls: no root ca pool loaded")
+	}
+	if len(rawCerts) == 0 {
+		return errors.New("no server certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parse server certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{Roots: m.rootCAPool, DNSName: r.cfg.ServerName, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("verify server certificate: %w", err)
+	}
+	return nil
+}
+
+// Close stops the background watcher.
+func (r *ReloadableTLS) Close() {
+	close(r.stop)
+}
+
+func (r *ReloadableTLS) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		r.logger.WithError(err).Warn("tls reload: fsnotify unavailable, falling back to polling")
+		r.pollLoop()
+		return
+	}
+	defer watcher.Close()
+
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile, r.cfg.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		if err := watcher.Add(f); err != nil {
+			r.logger.WithError(err).WithField("file", f).Warn("tls reload: failed to watch file, falling back to polling")
+			r.pollLoop()
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if err := r.reload(); err != nil {
+				r.logger.WithError(err).Warn("tls reload: keeping previous material after failed reload")
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			r.logger.WithError(err).Warn("tls reload: watcher error")
+		}
+	}
+}
+
+func (r *ReloadableTLS) pollLoop() {
+	interval := r.cfg.ReloadInterval
+	if interval <= 0 {
+		interval = defaultTLSReloadInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			if err := r.reload(); err != nil {
+				r.logger.WithError(err).Debug("tls reload: poll found no usable change")
+			}
+		}
+	}
+}
+
+// reload re-reads cert/key and CA material from disk. CertFile/KeyFile are
+// only required when at least one is set, or when neither CAFile nor
+// ClientCAFile is configured either — a ReloadableTLS used solely for its
+// ClientConfig() (verifying a server's certificate against a hot-reloadable
+// CAFile, with no client certificate to present) has no identity of its own
+// to load.
+func (r *ReloadableTLS) reload() error {
+	if (r.cfg.CertFile == "") != (r.cfg.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	hasIdentity := r.cfg.CertFile != "" && r.cfg.KeyFile != ""
+	if !hasIdentity && r.cfg.CAFile == "" && r.cfg.ClientCAFile == "" {
+		return fmt.Errorf("tls cert_file and key_file are required")
+	}
+
+	m := &tlsMaterial{}
+	fingerprint, notAfter := "none", "none"
+	if hasIdentity {
+		cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load key pair: %w", err)
+		}
+		if len(cert.Certificate) > 0 {
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return fmt.Errorf("parse leaf certificate: %w", err)
+			}
+			if time.Now().After(leaf.NotAfter) {
+				return fmt.Errorf("refusing reload: certificate expired at %s", leaf.NotAfter)
+			}
+			sum := sha256.Sum256(leaf.Raw)
+			fingerprint = hex.EncodeToString(sum[:])
+			notAfter = leaf.NotAfter.String()
+		}
+		m.cert = &cert
+	}
+	if r.cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client ca pool: %w", err)
+		}
+		m.clientCAPool = pool
+	}
+	if r.cfg.CAFile != "" {
+		pool, err := loadCertPool(r.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("load ca pool: %w", err)
+		}
+		m.rootCAPool = pool
+	}
+
+	r.material.Store(m)
+	r.logger.WithFields(logrus.Fields{
+		"fingerprint": fingerprint,
+		"not_after":   notAfter,
+	}).Info("tls: certificate material reloaded")
+	return nil
+}
+
+// ReloadablePins watches a file of SHA-256 leaf fingerprints (one hex string
+// per line, ':' separators allowed) and accepts a peer certificate whose
+// fingerprint appears in the current set, so operators can rotate pins by
+// editing the file instead of recompiling or restarting the client.
+type ReloadablePins struct {
+	path     string
+	interval time.Duration
+
+	pins atomic.Pointer[map[string]struct{}]
+	stop chan struct{}
+}
+
+// NewReloadablePins loads path and starts a poll-based watcher for changes.
+func NewReloadablePins(path string, interval time.Duration) (*ReloadablePins, error) {
+	if interval <= 0 {
+		interval = defaultTLSReloadInterval
+	}
+	p := &ReloadablePins{path: path, interval: interval, stop: make(chan struct{})}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	go p.pollLoop()
+	return p, nil
+}
+
+func (p *ReloadablePins) load() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("read pins file: %w", err)
+	}
+	pins := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fp, err := parseFingerprint(line)
+		if err != nil {
+			return fmt.Errorf("parse pin %q: %w", line, err)
+		}
+		pins[string(fp)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read pins file: %w", err)
+	}
+	p.pins.Store(&pins)
+	return nil
+}
+
+func (p *ReloadablePins) pollLoop() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			_ = p.load()
+		}
+	}
+}
+
+// Close stops the background watcher.
+func (p *ReloadablePins) Close() {
+	close(p.stop)
+}
+
+// VerifyPeerCertificate implements the tls.Config.VerifyPeerCertificate
+// signature, accepting the leaf if its fingerprint is in the current pin set.
+func (p *ReloadablePins) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate presented")
+	}
+	pins := p.pins.Load()
+	if pins == nil {
+		return fmt.Errorf("no pins loaded")
+	}
+	sum := sha256.Sum256(rawCerts[0])
+	if _, ok := (*pins)[string(sum[:])]; !ok {
+		return fmt.Errorf("certificate fingerprint not in pin set")
+	}
+	return nil
+}
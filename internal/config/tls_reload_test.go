@@ -0,0 +1,250 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReloadableTLSSwapsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	writeCert(t, certPath, keyPath, "127.0.0.1")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	r, err := NewReloadableTLS(TLSConfig{Enabled: true, CertFile: certPath, KeyFile: keyPath, ReloadInterval: 10 * time.Millisecond}, logger)
+	if err != nil {
+		t.Fatalf("new reloadable tls: %v", err)
+	}
+	defer r.Close()
+
+	first, err := r.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+
+	writeCert(t, certPath, keyPath, "other.example.com")
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	second, err := r.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	if string(first.Certificate[0]) == string(second.Certificate[0]) {
+		t.Fatalf("expected certificate to change after reload")
+	}
+}
+
+func TestReloadableTLSRefusesBadReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCert(t, certPath, keyPath, "127.0.0.1")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	r, err := NewReloadableTLS(TLSConfig{Enabled: true, CertFile: certPath, KeyFile: keyPath}, logger)
+	if err != nil {
+		t.Fatalf("new reloadable tls: %v", err)
+	}
+	defer r.Close()
+
+	if err := os.WriteFile(certPath, []byte("not a cert"), 0o644); err != nil {
+		t.Fatalf("corrupt cert: %v", err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatalf("expected reload to refuse unparseable cert")
+	}
+
+	if _, err := r.getCertificate(nil); err != nil {
+		t.Fatalf("expected previous certificate to remain usable: %v", err)
+	}
+}
+
+func TestReloadableTLSGetConfigForClientUsesClientCAFile(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCert(t, certPath, keyPath, "127.0.0.1")
+
+	caCert, _, err := GenerateSelfSigned("client-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	clientCAPath := filepath.Join(dir, "client-ca.pem")
+	if err := os.WriteFile(clientCAPath, caCert, 0o644); err != nil {
+		t.Fatalf("write client ca: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	r, err := NewReloadableTLS(TLSConfig{Enabled: true, CertFile: certPath, KeyFile: keyPath, ClientCAFile: clientCAPath}, logger)
+	if err != nil {
+		t.Fatalf("new reloadable tls: %v", err)
+	}
+	defer r.Close()
+
+	cfg, err := r.getConfigForClient(nil)
+	if err != nil {
+		t.Fatalf("get config for client: %v", err)
+	}
+	if cfg.ClientCAs == nil {
+		t.Fatalf("expected client ca pool to be populated from ClientCAFile")
+	}
+}
+
+func TestReloadableTLSClientConfigVerifiesServerCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCert(t, certPath, keyPath, "client-cert")
+
+	serverCert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, serverCert, 0o644); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	r, err := NewReloadableTLS(TLSConfig{Enabled: true, CertFile: certPath, KeyFile: keyPath, CAFile: caPath}, logger)
+	if err != nil {
+		t.Fatalf("new reloadable tls: %v", err)
+	}
+	defer r.Close()
+
+	clientCfg := r.ClientConfig()
+	if clientCfg.GetClientCertificate == nil {
+		t.Fatalf("expected GetClientCertificate to be set")
+	}
+	if cert, err := clientCfg.GetClientCertificate(nil); err != nil || cert == nil {
+		t.Fatalf("get client certificate: cert=%v err=%v", cert, err)
+	}
+
+	if err := clientCfg.VerifyPeerCertificate([][]byte{certDER(t, serverCert)}, nil); err != nil {
+		t.Fatalf("expected trusted server cert to verify: %v", err)
+	}
+
+	otherCert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	if err := clientCfg.VerifyPeerCertificate([][]byte{certDER(t, otherCert)}, nil); err == nil {
+		t.Fatalf("expected untrusted server cert to fail verification")
+	}
+}
+
+func TestReloadableTLSClientConfigWorksWithoutIdentity(t *testing.T) {
+	dir := t.TempDir()
+
+	serverCert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, serverCert, 0o644); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	r, err := NewReloadableTLS(TLSConfig{Enabled: true, CAFile: caPath}, logger)
+	if err != nil {
+		t.Fatalf("new reloadable tls without cert/key: %v", err)
+	}
+	defer r.Close()
+
+	clientCfg := r.ClientConfig()
+	if err := clientCfg.VerifyPeerCertificate([][]byte{certDER(t, serverCert)}, nil); err != nil {
+		t.Fatalf("expected trusted server cert to verify: %v", err)
+	}
+
+	otherCert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	if err := clientCfg.VerifyPeerCertificate([][]byte{certDER(t, otherCert)}, nil); err == nil {
+		t.Fatalf("expected untrusted server cert to fail verification")
+	}
+}
+
+func TestReloadableTLSRequiresCertAndKeyTogether(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeCert(t, certPath, keyPath, "127.0.0.1")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	if _, err := NewReloadableTLS(TLSConfig{Enabled: true, CertFile: certPath}, logger); err == nil {
+		t.Fatalf("expected cert_file without key_file to be rejected")
+	}
+	if _, err := NewReloadableTLS(TLSConfig{Enabled: true}, logger); err == nil {
+		t.Fatalf("expected neither identity nor CA material to be rejected")
+	}
+}
+
+func TestReloadablePinsRotates(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	sum := sha256.Sum256(certDER(t, cert))
+	fp := hex.EncodeToString(sum[:])
+
+	dir := t.TempDir()
+	pinsPath := filepath.Join(dir, "pins.txt")
+	if err := os.WriteFile(pinsPath, []byte("deadbeef"+strings.Repeat("00", 28)+"\n"), 0o644); err != nil {
+		t.Fatalf("write pins: %v", err)
+	}
+
+	pins, err := NewReloadablePins(pinsPath, time.Hour)
+	if err != nil {
+		t.Fatalf("new reloadable pins: %v", err)
+	}
+	defer pins.Close()
+
+	if err := pins.VerifyPeerCertificate([][]byte{certDER(t, cert)}, nil); err == nil {
+		t.Fatalf("expected unpinned cert to be rejected")
+	}
+
+	if err := os.WriteFile(pinsPath, []byte(fp+"\n"), 0o644); err != nil {
+		t.Fatalf("rewrite pins: %v", err)
+	}
+	if err := pins.load(); err != nil {
+		t.Fatalf("reload pins: %v", err)
+	}
+
+	if err := pins.VerifyPeerCertificate([][]byte{certDER(t, cert)}, nil); err != nil {
+		t.Fatalf("expected pinned cert to be accepted: %v", err)
+	}
+}
+
+func writeCert(t *testing.T, certPath, keyPath, host string) {
+	t.Helper()
+	cert, key, err := GenerateSelfSigned(host, time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	if err := os.WriteFile(certPath, cert, 0o644); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, key, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
@@ -2,12 +2,17 @@ package config
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 func TestServerTLSConfigLoadsCert(t *testing.T) {
@@ -69,3 +74,393 @@ func certDER(t *testing.T, pemBytes []byte) []byte {
 func pemDecode(b []byte) (*pem.Block, []byte) {
 	return pem.Decode(b)
 }
+
+func TestVerifyAllowedClientCertAcceptsMatchingCN(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	der := certDER(t, cert)
+
+	verify := verifyAllowedClientCert([]string{"alice"}, nil)
+	if err := verify([][]byte{der}, nil); err != nil {
+		t.Fatalf("expected matching CN to be accepted: %v", err)
+	}
+
+	verify = verifyAllowedClientCert([]string{"bob"}, nil)
+	if err := verify([][]byte{der}, nil); err == nil {
+		t.Fatalf("expected non-matching CN to be rejected")
+	}
+}
+
+func TestVerifyAllowedClientCertAcceptsMatchingFingerprint(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	der := certDER(t, cert)
+	sum := sha256.Sum256(der)
+	fp := hex.EncodeToString(sum[:])
+
+	verify := verifyAllowedClientCert(nil, []string{fp})
+	if err := verify([][]byte{der}, nil); err != nil {
+		t.Fatalf("expected matching fingerprint to be accepted: %v", err)
+	}
+
+	verify = verifyAllowedClientCert(nil, []string{"00112233445566778899aabbccddeeff00112233445566778899aabbccddee"})
+	if err := verify([][]byte{der}, nil); err == nil {
+		t.Fatalf("expected non-matching fingerprint to be rejected")
+	}
+}
+
+// TestServerTLSConfigEnforcesAllowedClientCNsOverRealHandshake drives an
+// actual TLS handshake end-to-end (rather than calling
+// verifyAllowedClientCert directly) to prove AllowedClientCNs is wired all
+// the way through ServerTLSConfig: a client certificate trusted by
+// ClientCAFile but whose CN isn't allow-listed must still be rejected.
+func TestServerTLSConfigEnforcesAllowedClientCNsOverRealHandshake(t *testing.T) {
+	serverCert, serverKey, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate server cert: %v", err)
+	}
+	aliceCert, aliceKey, err := GenerateSelfSigned("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("generate alice cert: %v", err)
+	}
+	malloryCert, malloryKey, err := GenerateSelfSigned("mallory", time.Hour)
+	if err != nil {
+		t.Fatalf("generate mallory cert: %v", err)
+	}
+
+	dir := t.TempDir()
+	serverCertPath := writeTempFile(t, dir, "server-cert.pem", serverCert)
+	serverKeyPath := writeTempFile(t, dir, "server-key.pem", serverKey)
+	// Both client certs are self-signed, so the CA bundle trusts each of
+	// them directly; only the CN allowlist distinguishes them.
+	clientCAPath := writeTempFile(t, dir, "client-ca.pem", append(append([]byte{}, aliceCert...), malloryCert...))
+
+	serverTLSCfg, err := ServerTLSConfig(TLSConfig{
+		Enabled:          true,
+		CertFile:         serverCertPath,
+		KeyFile:          serverKeyPath,
+		ClientCAFile:     clientCAPath,
+		ClientAuth:       "require-and-verify",
+		AllowedClientCNs: []string{"alice"},
+	})
+	if err != nil {
+		t.Fatalf("server tls config: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSCfg)
+	if err != nil {
+		t.Fatalf("listen tls: %v", err)
+	}
+	defer ln.Close()
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			acceptErr <- err
+			return
+		}
+		defer conn.Close()
+		acceptErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	dial := func(certPEM, keyPEM []byte) error {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatalf("load client keypair: %v", err)
+		}
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+			// TLS 1.3 sends the client's last flight (and the server's
+			// rejection of it) after the client's own Handshake() has
+			// already returned, so a rejection only surfaces on the next
+			// read. Pin to 1.2 here to get a synchronous handshake error.
+			MaxVersion: tls.VersionTLS12,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return conn.Handshake()
+	}
+
+	if err := dial(malloryCert, malloryKey); err == nil {
+		t.Fatalf("expected handshake with a CN outside allowed_client_cns to be rejected")
+	}
+	if err := <-acceptErr; err == nil {
+		t.Fatalf("expected server side to also reject mallory's handshake")
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			acceptErr <- err
+			return
+		}
+		defer conn.Close()
+		acceptErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	if err := dial(aliceCert, aliceKey); err != nil {
+		t.Fatalf("expected handshake with an allowed CN to succeed: %v", err)
+	}
+	if err := <-acceptErr; err != nil {
+		t.Fatalf("expected server side to accept alice's handshake: %v", err)
+	}
+}
+
+func TestComputeSPKIPinRoundTripsThroughVerifySPKIPins(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	der := certDER(t, cert)
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	pin := ComputeSPKIPin(leaf)
+
+	verify, err := verifySPKIPins([]string{pin})
+	if err != nil {
+		t.Fatalf("verify spki pins: %v", err)
+	}
+	if err := verify([][]byte{der}, nil); err != nil {
+		t.Fatalf("expected pinned SPKI to be accepted: %v", err)
+	}
+
+	other, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	if err := verify([][]byte{certDER(t, other)}, nil); err == nil {
+		t.Fatalf("expected unpinned SPKI to be rejected")
+	}
+}
+
+func TestParseSPKIPinRejectsMalformed(t *testing.T) {
+	if _, err := parseSPKIPin("md5/deadbeef"); err == nil {
+		t.Fatalf("expected error for wrong prefix")
+	}
+	if _, err := parseSPKIPin("sha256/not-base64!!"); err == nil {
+		t.Fatalf("expected error for invalid base64")
+	}
+}
+
+func TestClientTLSConfigWithCertPins(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(certDER(t, cert))
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	cfg := TLSConfig{Enabled: true, CertPins: []string{ComputeSPKIPin(leaf)}}
+	tlsCfg, err := ClientTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("client tls config: %v", err)
+	}
+	if tlsCfg.VerifyPeerCertificate == nil {
+		t.Fatalf("expected verify peer certificate set")
+	}
+	if !tlsCfg.InsecureSkipVerify {
+		t.Fatalf("expected InsecureSkipVerify to defer to VerifyPeerCertificate")
+	}
+}
+
+func TestVerifyPeerAddressAcceptsMatchingIPSAN(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(certDER(t, cert))
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	if err := VerifyPeerAddress(leaf, addr, nil); err != nil {
+		t.Fatalf("expected matching IP SAN to be accepted: %v", err)
+	}
+
+	other := &net.TCPAddr{IP: net.ParseIP("10.0.0.9"), Port: 1234}
+	if err := VerifyPeerAddress(leaf, other, nil); err == nil {
+		t.Fatalf("expected non-matching IP to be rejected")
+	}
+}
+
+func TestVerifyPeerAddressEnforcesAllowedSANs(t *testing.T) {
+	cert, _, err := GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("generate self-signed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(certDER(t, cert))
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+
+	if err := VerifyPeerAddress(leaf, addr, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("expected SAN in allowlist to be accepted: %v", err)
+	}
+	if err := VerifyPeerAddress(leaf, addr, []string{"192.0.2.1"}); err == nil {
+		t.Fatalf("expected SAN not in allowlist to be rejected")
+	}
+}
+
+func TestGenerateSelfSignedPKCS12RoundTripsThroughServerTLSConfig(t *testing.T) {
+	p12, err := GenerateSelfSignedPKCS12("127.0.0.1", time.Hour, "hunter2")
+	if err != nil {
+		t.Fatalf("generate self-signed pkcs12: %v", err)
+	}
+	dir := t.TempDir()
+	p12Path := filepath.Join(dir, "bundle.p12")
+	if err := os.WriteFile(p12Path, p12, 0o600); err != nil {
+		t.Fatalf("write pkcs12 bundle: %v", err)
+	}
+
+	cfg := TLSConfig{Enabled: true, PKCS12File: p12Path, PKCS12Password: "hunter2"}
+	tlsCfg, err := ServerTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("server tls config: %v", err)
+	}
+	if len(tlsCfg.Certificates) == 0 {
+		t.Fatalf("expected certificates loaded from pkcs12 bundle")
+	}
+}
+
+func TestLoadPKCS12KeyPairRejectsWrongPassword(t *testing.T) {
+	p12, err := GenerateSelfSignedPKCS12("127.0.0.1", time.Hour, "hunter2")
+	if err != nil {
+		t.Fatalf("generate self-signed pkcs12: %v", err)
+	}
+	dir := t.TempDir()
+	p12Path := filepath.Join(dir, "bundle.p12")
+	if err := os.WriteFile(p12Path, p12, 0o600); err != nil {
+		t.Fatalf("write pkcs12 bundle: %v", err)
+	}
+
+	if _, err := loadPKCS12KeyPair(p12Path, "wrong"); err == nil {
+		t.Fatalf("expected wrong password to be rejected")
+	}
+}
+
+func TestEncodeSelfSignedPKCS12ReusesGeneratedIdentity(t *testing.T) {
+	for _, kt := range []KeyType{KeyTypeRSA2048, KeyTypeECDSAP256, KeyTypeEd25519} {
+		certPEM, keyPEM, err := GenerateSelfSignedWithKeyType("127.0.0.1", time.Hour, kt)
+		if err != nil {
+			t.Fatalf("%s: generate self-signed: %v", kt, err)
+		}
+		p12, err := EncodeSelfSignedPKCS12(certPEM, keyPEM, "hunter2")
+		if err != nil {
+			t.Fatalf("%s: encode pkcs12: %v", kt, err)
+		}
+
+		pemLeaf, err := x509.ParseCertificate(certDER(t, certPEM))
+		if err != nil {
+			t.Fatalf("%s: parse pem certificate: %v", kt, err)
+		}
+
+		_, p12Leaf, _, err := pkcs12.DecodeChain(p12, "hunter2")
+		if err != nil {
+			t.Fatalf("%s: decode pkcs12: %v", kt, err)
+		}
+
+		if pemLeaf.SerialNumber.Cmp(p12Leaf.SerialNumber) != 0 {
+			t.Fatalf("%s: expected pkcs12 bundle to carry the same certificate as the PEM pair, got different serial numbers", kt)
+		}
+	}
+}
+
+func TestGenerateSelfSignedWithKeyType(t *testing.T) {
+	for _, kt := range []KeyType{KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519} {
+		cert, key, err := GenerateSelfSignedWithKeyType("127.0.0.1", time.Hour, kt)
+		if err != nil {
+			t.Fatalf("%s: generate: %v", kt, err)
+		}
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			t.Fatalf("%s: load generated key pair: %v", kt, err)
+		}
+	}
+}
+
+func TestParseKeyTypeRejectsUnknown(t *testing.T) {
+	if _, err := ParseKeyType("rot13"); err == nil {
+		t.Fatalf("expected an error for an unknown key type")
+	}
+	if kt, err := ParseKeyType(""); err != nil || kt != KeyTypeRSA2048 {
+		t.Fatalf("expected empty key type to default to rsa2048, got %q, %v", kt, err)
+	}
+}
+
+func TestEnsureSelfSignedCertGeneratesWhenMissing(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	cfg := TLSConfig{Enabled: true, AutoGenerate: true, CertFile: certPath, KeyFile: keyPath}
+	if err := EnsureSelfSignedCert(cfg); err != nil {
+		t.Fatalf("ensure self-signed cert: %v", err)
+	}
+	if _, err := os.Stat(certPath); err != nil {
+		t.Fatalf("expected cert file to be written: %v", err)
+	}
+	if _, err := os.Stat(keyPath); err != nil {
+		t.Fatalf("expected key file to be written: %v", err)
+	}
+}
+
+func TestEnsureSelfSignedCertLeavesExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, []byte("existing cert"), 0o644); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, []byte("existing key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	cfg := TLSConfig{Enabled: true, AutoGenerate: true, CertFile: certPath, KeyFile: keyPath}
+	if err := EnsureSelfSignedCert(cfg); err != nil {
+		t.Fatalf("ensure self-signed cert: %v", err)
+	}
+
+	got, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	if string(got) != "existing cert" {
+		t.Fatalf("expected existing cert to be left untouched, got %q", got)
+	}
+}
+
+func TestEnsureSelfSignedCertNoopWhenDisabled(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	cfg := TLSConfig{Enabled: true, AutoGenerate: false, CertFile: certPath, KeyFile: keyPath}
+	if err := EnsureSelfSignedCert(cfg); err != nil {
+		t.Fatalf("ensure self-signed cert: %v", err)
+	}
+	if _, err := os.Stat(certPath); err == nil {
+		t.Fatalf("expected no cert file to be written when AutoGenerate is false")
+	}
+}
+
+func writeTempFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
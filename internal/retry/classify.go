@@ -0,0 +1,49 @@
+package retry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/njangra/falcon-tunnel/internal/auth"
+)
+
+// ShouldRetry reports whether err is worth retrying. It returns false for
+// failures no amount of retrying will fix: a rejected credential, a peer
+// certificate that fails our own verification, or the caller giving up via
+// context cancellation. Everything else — including a plain net.OpError
+// from a refused or timed-out dial, and a TLS alert from a peer that
+// rejected our certificate — is treated as transient and worth
+// another attempt, since an unrecognized error shape is more likely a
+// momentary network hiccup than a permanent one.
+func ShouldRetry(err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, auth.ErrAuthFailed) || errors.Is(err, auth.ErrInvalidResponse) {
+		return false
+	}
+
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return false
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return false
+	}
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		return false
+	}
+
+	return true
+}
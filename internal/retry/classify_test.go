@@ -0,0 +1,36 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/njangra/falcon-tunnel/internal/auth"
+)
+
+func TestShouldRetryTransientNetworkError(t *testing.T) {
+	err := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	if !ShouldRetry(err) {
+		t.Fatalf("expected a plain net.OpError to be retryable")
+	}
+}
+
+func TestShouldRetryRejectsAuthFailure(t *testing.T) {
+	if ShouldRetry(auth.ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed to be terminal")
+	}
+	if ShouldRetry(fmt.Errorf("auth handshake: %w", auth.ErrAuthFailed)) {
+		t.Fatalf("expected a wrapped ErrAuthFailed to be terminal")
+	}
+}
+
+func TestShouldRetryRejectsContextCancellation(t *testing.T) {
+	if ShouldRetry(context.Canceled) {
+		t.Fatalf("expected context.Canceled to be terminal")
+	}
+	if ShouldRetry(context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded to be terminal")
+	}
+}
@@ -0,0 +1,62 @@
+// Package retry provides a backoff pacer and error classifier shared by the
+// tunnel client's dial and auth-handshake retry loops.
+package retry
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Pacer paces retry attempts with exponential backoff and full jitter,
+// modeled on rclone's FTP backend pacer: each call to Backoff returns a
+// uniformly random duration in [0, current], then doubles current toward
+// Max. Reset clears the decay after a successful attempt so a later,
+// unrelated failure doesn't inherit an already-maxed-out backoff.
+//
+// A Pacer is safe for concurrent use.
+type Pacer struct {
+	min time.Duration
+	max time.Duration
+
+	mu      sync.Mutex
+	current time.Duration
+}
+
+// NewPacer returns a Pacer starting at min and capped at max. A non-positive
+// min is raised to 1ms and a max below min is raised to min, so a zero-value
+// caller configuration can't produce a zero-length or inverted backoff.
+func NewPacer(min, max time.Duration) *Pacer {
+	if min <= 0 {
+		min = time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	return &Pacer{min: min, max: max}
+}
+
+// Backoff returns the next sleep duration and advances the pacer's internal
+// exponential counter.
+func (p *Pacer) Backoff() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == 0 {
+		p.current = p.min
+	}
+	wait := time.Duration(rand.Int63n(int64(p.current) + 1))
+	p.current *= 2
+	if p.current > p.max {
+		p.current = p.max
+	}
+	return wait
+}
+
+// Reset clears the pacer's decay so the next Backoff call starts again at
+// min. Callers should call this after a successful attempt.
+func (p *Pacer) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = 0
+}
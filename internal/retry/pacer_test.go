@@ -0,0 +1,35 @@
+package retry
+
+import "testing"
+
+func TestPacerBackoffCapsAtMax(t *testing.T) {
+	p := NewPacer(10, 100)
+	for i := 0; i < 20; i++ {
+		if wait := p.Backoff(); wait > 100 {
+			t.Fatalf("backoff %v exceeded max 100", wait)
+		}
+	}
+}
+
+func TestPacerResetRestartsDecay(t *testing.T) {
+	p := NewPacer(10, 1000)
+	for i := 0; i < 10; i++ {
+		p.Backoff()
+	}
+	p.Reset()
+	if wait := p.Backoff(); wait > 10 {
+		t.Fatalf("expected backoff immediately after reset to be bounded by min 10, got %v", wait)
+	}
+}
+
+func TestNewPacerRejectsZeroAndInverted(t *testing.T) {
+	p := NewPacer(0, 0)
+	if p.min <= 0 || p.max < p.min {
+		t.Fatalf("expected zero min/max to be normalized, got min=%v max=%v", p.min, p.max)
+	}
+
+	p = NewPacer(50, 10)
+	if p.max < p.min {
+		t.Fatalf("expected max raised to at least min, got min=%v max=%v", p.min, p.max)
+	}
+}
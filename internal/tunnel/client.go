@@ -10,6 +10,8 @@ import (
 
 	"github.com/njangra/falcon-tunnel/internal/auth"
 	"github.com/njangra/falcon-tunnel/internal/config"
+	"github.com/njangra/falcon-tunnel/internal/retry"
+	"github.com/njangra/falcon-tunnel/pkg/protocol"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,17 +22,36 @@ type Client struct {
 	tlsCfg  *tls.Config
 	mu      sync.Mutex
 	running bool
+
+	// sess is the shared multiplexed tunnel connection used when
+	// cfg.Client.Multiplex is set. It is dialed lazily on first use and
+	// re-dialed if the peer closes it.
+	sessMu sync.Mutex
+	sess   *Session
+
+	// token is the most recently issued resumption token, if the server is
+	// configured with a TokenStore. A reconnect presents it via
+	// ResumeClient to skip re-entering the password.
+	tokenMu sync.Mutex
+	token   string
+
+	notifiers []Notifier
 }
 
-// NewClient constructs a Client with defaults.
-func NewClient(cfg config.Config, logger *logrus.Logger, tlsCfg *tls.Config) *Client {
+// NewClient constructs a Client with defaults. notifiers, if given, replace
+// the default LogrusNotifier for connect/auth/disconnect events; see Notifier.
+func NewClient(cfg config.Config, logger *logrus.Logger, tlsCfg *tls.Config, notifiers ...Notifier) *Client {
 	if logger == nil {
 		logger = logrus.New()
 	}
+	if len(notifiers) == 0 {
+		notifiers = []Notifier{NewLogrusNotifier(logger)}
+	}
 	return &Client{
-		cfg:    cfg,
-		logger: logger,
-		tlsCfg: tlsCfg,
+		cfg:       cfg,
+		logger:    logger,
+		tlsCfg:    tlsCfg,
+		notifiers: notifiers,
 	}
 }
 
@@ -54,6 +75,12 @@ func (c *Client) Start(ctx context.Context) error {
 	go func() {
 		<-ctx.Done()
 		_ = ln.Close()
+		c.sessMu.Lock()
+		sess := c.sess
+		c.sessMu.Unlock()
+		if sess != nil {
+			_ = sess.CloseGracefully()
+		}
 	}()
 
 	var wg sync.WaitGroup
@@ -83,24 +110,232 @@ func (c *Client) Start(ctx context.Context) error {
 func (c *Client) handleLocalConn(ctx context.Context, localConn net.Conn) error {
 	defer localConn.Close()
 
+	if c.cfg.Client.Multiplex {
+		return c.handleLocalConnMultiplexed(ctx, localConn)
+	}
+
+	// sentBuf/recvBuf track this local connection's traffic across
+	// reconnects so a resume can replay whatever the other side is
+	// missing; see exchangeResumeAcks.
+	sentBuf, recvBuf := newRingBuffer(), newRingBuffer()
+
+	start := time.Now()
+	defer func() {
+		notifyAll(c.notifiers, func(n Notifier) {
+			n.OnDisconnect(c.cfg.Client.TunnelAddr, c.cfg.Client.Username, recvBuf.End(), sentBuf.End(), time.Since(start))
+		})
+	}()
+
+	tunnelConn, err := c.dialAndAuthenticateWithRetry(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		c.logger.WithFields(logrus.Fields{
+			"local":  localConn.RemoteAddr().String(),
+			"server": c.cfg.Client.TunnelAddr,
+		}).Info("proxy connection established (client)")
+
+		copyErr := proxyWithIdleResumable(tunnelConn, localConn, c.cfg.Client.IdleTimeout, sentBuf, recvBuf)
+		if copyErr == nil || !c.cfg.Auth.Enabled || c.getToken() == "" {
+			return copyErr
+		}
+		select {
+		case <-ctx.Done():
+			return copyErr
+		default:
+		}
+
+		c.logger.WithError(copyErr).Debug("tunnel connection dropped, attempting to resume")
+		tunnelConn, err = c.dialAndAuthenticateWithRetry(ctx, recvBuf, sentBuf)
+		if err != nil {
+			return fmt.Errorf("resume after drop: %w", err)
+		}
+	}
+}
+
+// dialAndAuthenticate dials the tunnel server and authenticates, preferring
+// a stored resumption token over the password. If the server rejects the
+// token (expired, unknown, or no TokenStore configured), this closes that
+// connection, redials once and falls back to a full username/password
+// handshake.
+//
+// recvBuf and sentBuf, when non-nil, track this connection's traffic across
+// reconnects for single-stream mode's byte-replay resumption; see
+// exchangeResumeAcks. Callers that don't need replay (e.g. the multiplexed
+// session, which re-establishes its streams from scratch on reconnect) pass
+// nil for both.
+func (c *Client) dialAndAuthenticate(ctx context.Context, recvBuf, sentBuf *ringBuffer) (net.Conn, error) {
 	tunnelConn, err := c.dialWithRetry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remote := tunnelConn.RemoteAddr().String()
+	notifyAll(c.notifiers, func(n Notifier) { n.OnConnect(remote) })
+	if !c.cfg.Auth.Enabled {
+		return tunnelConn, nil
+	}
+
+	if token := c.getToken(); token != "" {
+		newToken, err := auth.ResumeClient(tunnelConn, token, c.cfg.Client.Timeout)
+		if err == nil {
+			c.setToken(newToken)
+			if recvBuf != nil && sentBuf != nil {
+				if err := exchangeResumeAcks(tunnelConn, recvBuf, sentBuf, c.cfg.Client.Timeout); err != nil {
+					_ = tunnelConn.Close()
+					return nil, fmt.Errorf("resume replay: %w", err)
+				}
+			}
+			notifyAll(c.notifiers, func(n Notifier) { n.OnAuthSuccess(remote, c.cfg.Client.Username) })
+			return tunnelConn, nil
+		}
+		c.logger.WithError(err).Debug("session resume rejected, falling back to password auth")
+		c.setToken("")
+		_ = tunnelConn.Close()
+		tunnelConn, err = c.dialWithRetry(ctx)
+		if err != nil {
+			return nil, err
+		}
+		remote = tunnelConn.RemoteAddr().String()
+		notifyAll(c.notifiers, func(n Notifier) { n.OnConnect(remote) })
+	}
+
+	newToken, err := auth.HandshakeClient(tunnelConn, c.cfg.Client.Username, c.cfg.Client.Password, c.cfg.Client.Timeout)
+	if err != nil {
+		notifyAll(c.notifiers, func(n Notifier) { n.OnAuthFailure(remote, err) })
+		_ = tunnelConn.Close()
+		return nil, fmt.Errorf("auth handshake: %w", err)
+	}
+	c.setToken(newToken)
+	notifyAll(c.notifiers, func(n Notifier) { n.OnAuthSuccess(remote, c.cfg.Client.Username) })
+	return tunnelConn, nil
+}
+
+// exchangeResumeAcks runs immediately after a successful resume. It tells
+// the server how much data this client already received (recvBuf.End()) so
+// the server can replay anything beyond that as ordinary bytes once
+// proxying resumes, then reads back how much of this client's outbound data
+// the server already received and, if the server actually reattached a
+// dropped connection, replays the gap from sentBuf so the server doesn't
+// miss any FTP command or data bytes.
+func exchangeResumeAcks(conn net.Conn, recvBuf, sentBuf *ringBuffer, deadline time.Duration) error {
+	if deadline > 0 {
+		_ = conn.SetDeadline(time.Now().Add(deadline))
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAck, Payload: encodeAck(recvBuf.End(), true)})
 	if err != nil {
 		return err
 	}
-	defer tunnelConn.Close()
+	if _, err := conn.Write(frame); err != nil {
+		return fmt.Errorf("send resume ack: %w", err)
+	}
 
-	if c.cfg.Auth.Enabled {
-		if err := auth.HandshakeClient(tunnelConn, c.cfg.Client.Password, c.cfg.Client.Timeout); err != nil {
-			return fmt.Errorf("auth handshake: %w", err)
+	msg, err := protocol.Decode(conn)
+	if err != nil {
+		return fmt.Errorf("read resume ack: %w", err)
+	}
+	if msg.Type != protocol.MsgAck {
+		return fmt.Errorf("expected ack, got message type %d", msg.Type)
+	}
+	serverOffset, resumed, ok := decodeAck(msg.Payload)
+	if !ok || !resumed {
+		return nil
+	}
+	replay, ok := sentBuf.Since(serverOffset)
+	if !ok || len(replay) == 0 {
+		return nil
+	}
+	if _, err := conn.Write(replay); err != nil {
+		return fmt.Errorf("replay to server: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) getToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.token
+}
+
+func (c *Client) setToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.token = token
+}
+
+// handleLocalConnMultiplexed forwards localConn over a stream on the shared
+// tunnel session, dialing and authenticating the session on first use (or
+// after the peer has torn it down).
+func (c *Client) handleLocalConnMultiplexed(ctx context.Context, localConn net.Conn) error {
+	start := time.Now()
+	// Bytes aren't tracked per-stream here (see Session), so OnDisconnect
+	// reports 0,0 in multiplexed mode; only the connection's lifetime is
+	// known at this level.
+	defer func() {
+		notifyAll(c.notifiers, func(n Notifier) {
+			n.OnDisconnect(c.cfg.Client.TunnelAddr, c.cfg.Client.Username, 0, 0, time.Since(start))
+		})
+	}()
+
+	sess, err := c.session(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := sess.OpenStream()
+	if err != nil {
+		// The shared session died between acquiring it and opening a
+		// stream; drop it so the next caller redials.
+		c.sessMu.Lock()
+		if c.sess == sess {
+			c.sess = nil
 		}
+		c.sessMu.Unlock()
+		return fmt.Errorf("open tunnel stream: %w", err)
 	}
+	defer stream.Close()
 
 	c.logger.WithFields(logrus.Fields{
 		"local":  localConn.RemoteAddr().String(),
 		"server": c.cfg.Client.TunnelAddr,
-	}).Info("proxy connection established (client)")
+		"stream": stream.ID(),
+	}).Info("proxy connection established (client, multiplexed)")
 
-	return proxyWithIdle(localConn, tunnelConn, c.cfg.Client.IdleTimeout)
+	if c.cfg.Client.FTPMode {
+		return proxyFTPControlClient(c.logger, localConn, sess, stream)
+	}
+	return proxyStream(localConn, stream)
+}
+
+// session returns the shared multiplexed tunnel session, dialing and
+// authenticating a new one if none is established.
+func (c *Client) session(ctx context.Context) (*Session, error) {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	if c.sess != nil {
+		return c.sess, nil
+	}
+
+	tunnelConn, err := c.dialAndAuthenticateWithRetry(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var onOpen func(*Stream)
+	if c.cfg.Client.FTPMode {
+		// The only streams the server ever opens toward the client are
+		// MsgOpenData ones, for an active-mode (PORT/EPRT) data channel
+		// whose real peer is the local FTP client's own listener; see
+		// ftp.go.
+		onOpen = func(stream *Stream) {
+			go acceptDataStream(c.logger, c.cfg.Client.Timeout, stream)
+		}
+	}
+	c.sess = NewSession(tunnelConn, c.cfg.Client.IdleTimeout, 0, onOpen)
+	return c.sess, nil
 }
 
 func (c *Client) dialWithRetry(ctx context.Context) (net.Conn, error) {
@@ -108,31 +343,67 @@ func (c *Client) dialWithRetry(ctx context.Context) (net.Conn, error) {
 	if attempts == 0 {
 		attempts = 1
 	}
-	backoff := c.cfg.Client.BackoffInitial
-	if backoff == 0 {
-		backoff = 500 * time.Millisecond
+	backoffInitial := c.cfg.Client.BackoffInitial
+	if backoffInitial == 0 {
+		backoffInitial = 500 * time.Millisecond
 	}
-	maxBackoff := c.cfg.Client.BackoffMax
-	if maxBackoff == 0 {
-		maxBackoff = 5 * time.Second
+	backoffMax := c.cfg.Client.BackoffMax
+	if backoffMax == 0 {
+		backoffMax = 5 * time.Second
 	}
+	pacer := retry.NewPacer(backoffInitial, backoffMax)
 
 	for i := 0; ; i++ {
 		conn, err := c.dialOnce(ctx)
 		if err == nil {
 			return conn, nil
 		}
-		if i+1 >= attempts {
+		if i+1 >= attempts || !retry.ShouldRetry(err) {
 			return nil, fmt.Errorf("dial tunnel server: %w", err)
 		}
 		select {
-		case <-time.After(backoff):
+		case <-time.After(pacer.Backoff()):
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		}
-		backoff *= 2
-		if backoff > maxBackoff {
-			backoff = maxBackoff
+	}
+}
+
+// dialAndAuthenticateWithRetry wraps dialAndAuthenticate with the same
+// backoff pacer used by dialWithRetry, but applied to the combined
+// dial-and-handshake attempt rather than just the TCP dial: a server that's
+// momentarily refusing connections or timing out mid-handshake is worth
+// retrying, but retry.ShouldRetry stops immediately on a rejected
+// credential (auth.ErrAuthFailed) or a certificate verification failure, so
+// a wrong password fails fast instead of burning through MaxRetries.
+func (c *Client) dialAndAuthenticateWithRetry(ctx context.Context, recvBuf, sentBuf *ringBuffer) (net.Conn, error) {
+	attempts := c.cfg.Client.MaxRetries
+	if attempts == 0 {
+		attempts = 1
+	}
+	backoffInitial := c.cfg.Client.BackoffInitial
+	if backoffInitial == 0 {
+		backoffInitial = 500 * time.Millisecond
+	}
+	backoffMax := c.cfg.Client.BackoffMax
+	if backoffMax == 0 {
+		backoffMax = 5 * time.Second
+	}
+	pacer := retry.NewPacer(backoffInitial, backoffMax)
+
+	for i := 0; ; i++ {
+		conn, err := c.dialAndAuthenticate(ctx, recvBuf, sentBuf)
+		if err == nil {
+			return conn, nil
+		}
+		if i+1 >= attempts || !retry.ShouldRetry(err) {
+			return nil, err
+		}
+		c.logger.WithError(err).Debug("dial/auth attempt failed, retrying")
+		select {
+		case <-time.After(pacer.Backoff()):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
 }
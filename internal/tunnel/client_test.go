@@ -236,6 +236,143 @@ func TestClientEndToEndEchoTLS(t *testing.T) {
 	}
 }
 
+// TestClientEndToEndEchoTLSMutualCert exercises mutual TLS with cert-only
+// identity auth: the server requires and verifies a client certificate
+// signed by (here, identical to) ClientCAFile, and auth.Authenticator
+// accepts the verified certificate's CommonName in place of a password.
+func TestClientEndToEndEchoTLSMutualCert(t *testing.T) {
+	ftpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ftp listen: %v", err)
+	}
+	defer ftpLn.Close()
+	go acceptAndEcho(ftpLn)
+
+	serverCert, serverKey, err := config.GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("self-signed server cert: %v", err)
+	}
+	clientCert, clientKey, err := config.GenerateSelfSigned("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("self-signed client cert: %v", err)
+	}
+
+	dir := t.TempDir()
+	serverCertPath := writeTempFile(t, dir, "server-cert.pem", serverCert)
+	serverKeyPath := writeTempFile(t, dir, "server-key.pem", serverKey)
+	clientCertPath := writeTempFile(t, dir, "client-cert.pem", clientCert)
+	clientKeyPath := writeTempFile(t, dir, "client-key.pem", clientKey)
+
+	serverTLSCfg := config.TLSConfig{
+		Enabled:      true,
+		CertFile:     serverCertPath,
+		KeyFile:      serverKeyPath,
+		ClientCAFile: clientCertPath,
+		ClientAuth:   "require-and-verify",
+	}
+	serverTLS, err := config.ServerTLSConfig(serverTLSCfg)
+	if err != nil {
+		t.Fatalf("server tls config: %v", err)
+	}
+
+	serverLn, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("server listen tls: %v", err)
+	}
+	defer serverLn.Close()
+
+	serverCfg := config.Config{
+		Server: config.ServerConfig{
+			ListenAddr:     serverLn.Addr().String(),
+			FTPServerAddr:  ftpLn.Addr().String(),
+			MaxConnections: 10,
+			Timeout:        2 * time.Second,
+		},
+		Auth: config.AuthConfig{
+			Enabled:  true,
+			Backends: []string{"cert://?cn=alice"},
+		},
+		TLS: serverTLSCfg,
+		Log: config.LogConfig{
+			Level:  "error",
+			Format: "text",
+		},
+	}
+
+	server := NewServer(serverCfg, nil, logrus.New())
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	defer serverCancel()
+	go func() {
+		_ = server.Serve(serverCtx, serverLn)
+	}()
+
+	localPort := pickFreePort(t)
+
+	clientCfg := config.Config{
+		Client: config.ClientConfig{
+			TunnelAddr:   serverLn.Addr().String(),
+			LocalFTPPort: localPort,
+			Timeout:      2 * time.Second,
+		},
+		Auth: config.AuthConfig{
+			Enabled: true,
+		},
+		TLS: config.TLSConfig{
+			Enabled:    true,
+			CertFile:   clientCertPath,
+			KeyFile:    clientKeyPath,
+			CAFile:     serverCertPath,
+			ServerName: "127.0.0.1",
+		},
+		Log: config.LogConfig{
+			Level:  "error",
+			Format: "text",
+		},
+	}
+
+	clientTLS, err := config.ClientTLSConfig(clientCfg.TLS)
+	if err != nil {
+		t.Fatalf("client tls config: %v", err)
+	}
+
+	client := NewClient(clientCfg, logrus.New(), clientTLS)
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+	go func() {
+		_ = client.Start(clientCtx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	ftpClient, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		t.Fatalf("ftp client dial: %v", err)
+	}
+	defer ftpClient.Close()
+
+	payload := []byte("hello through mutual tls tunnel")
+	if _, err := ftpClient.Write(payload); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	buf := make([]byte, len(payload))
+	if _, err := ftpClient.Read(buf); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if string(buf) != string(payload) {
+		t.Fatalf("expected echo %q got %q", payload, buf)
+	}
+}
+
+func writeTempFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
 func pickFreePort(t *testing.T) int {
 	t.Helper()
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -0,0 +1,382 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file implements FTP-aware active/passive data channel handling for
+// multiplexed sessions (see Server.cfg.Server.FTPMode / Client.cfg.Client.FTPMode).
+// A plain tunneled FTP control connection forwards PASV/EPSV replies and
+// PORT/EPRT commands verbatim, but the host:port they carry is only
+// meaningful on the side of the tunnel that issued it — the real FTP client
+// can't reach a backend server's real PASV address directly, and the real
+// backend server can't reach the real FTP client's PORT address either.
+// Each side therefore intercepts the address it's able to doctor, opens a
+// shadow listener standing in for it, and carries the resulting data
+// connection over a second multiplexed stream (opened via
+// Session.OpenDataStream/MsgOpenData) rather than a second tunnel
+// connection.
+//
+// The client handles PASV/EPSV (proxyFTPControlClient): it owns the
+// listener the real FTP client will connect to. The server handles
+// PORT/EPRT (proxyFTPControlServer): it owns the listener the real backend
+// will connect to. Whichever side did NOT open the listener instead dials
+// the real address once the stream arrives; see acceptDataStream.
+
+var (
+	// pasvReplyPattern matches a PASV (227) reply's comma-separated
+	// h1,h2,h3,h4,p1,p2 address, e.g. "227 Entering Passive Mode (127,0,0,1,200,22)."
+	pasvReplyPattern = regexp.MustCompile(`\((\d+,\d+,\d+,\d+,\d+,\d+)\)`)
+	// epsvReplyPattern matches an EPSV (229) reply, which carries only a
+	// port; the host is the control connection's own address.
+	epsvReplyPattern = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+	// portCommandPattern matches a PORT command's comma-separated address.
+	portCommandPattern = regexp.MustCompile(`(?i)^PORT\s+(\d+,\d+,\d+,\d+,\d+,\d+)`)
+	// eprtCommandPattern matches an EPRT command's IPv4 (proto 1) form;
+	// IPv6 (proto 2) data channels aren't supported.
+	eprtCommandPattern = regexp.MustCompile(`(?i)^EPRT\s+\|1\|([^|]+)\|(\d+)\|`)
+)
+
+// parsePASVStyleAddr decodes the h1,h2,h3,h4,p1,p2 encoding shared by PASV
+// replies and PORT commands into a dialable "host:port".
+func parsePASVStyleAddr(enc string) (string, error) {
+	parts := strings.Split(enc, ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("ftp: malformed address %q", enc)
+	}
+	var nums [6]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 {
+			return "", fmt.Errorf("ftp: malformed address %q", enc)
+		}
+		nums[i] = n
+	}
+	host := fmt.Sprintf("%d.%d.%d.%d", nums[0], nums[1], nums[2], nums[3])
+	port := nums[4]*256 + nums[5]
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// formatPASVStyleAddr is parsePASVStyleAddr's inverse.
+func formatPASVStyleAddr(host string, port int) (string, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("ftp: %q is not an IP address", host)
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", fmt.Errorf("ftp: %q is not an IPv4 address", host)
+	}
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", ip4[0], ip4[1], ip4[2], ip4[3], port/256, port%256), nil
+}
+
+// parsePassiveReply extracts the real data-channel address from a PASV
+// (227) or EPSV (229) server reply line. controlHost fills in the address
+// for EPSV, whose reply omits it. ok is false if line isn't a recognized
+// passive reply, or is one but malformed.
+func parsePassiveReply(line, controlHost string) (addr string, ok bool) {
+	if m := pasvReplyPattern.FindStringSubmatch(line); m != nil {
+		a, err := parsePASVStyleAddr(m[1])
+		return a, err == nil
+	}
+	if m := epsvReplyPattern.FindStringSubmatch(line); m != nil {
+		port, err := strconv.Atoi(m[1])
+		if err != nil {
+			return "", false
+		}
+		return net.JoinHostPort(controlHost, strconv.Itoa(port)), true
+	}
+	return "", false
+}
+
+// rewritePassiveReply replaces the address embedded in a PASV/EPSV reply
+// with host:port, preserving which form (227 vs 229) the server used.
+func rewritePassiveReply(line, host string, port int) (string, error) {
+	if pasvReplyPattern.MatchString(line) {
+		enc, err := formatPASVStyleAddr(host, port)
+		if err != nil {
+			return "", err
+		}
+		return pasvReplyPattern.ReplaceAllString(line, "("+enc+")"), nil
+	}
+	if epsvReplyPattern.MatchString(line) {
+		return epsvReplyPattern.ReplaceAllString(line, fmt.Sprintf("(|||%d|)", port)), nil
+	}
+	return "", fmt.Errorf("ftp: not a passive reply")
+}
+
+// parseActiveCommand extracts the real data-channel address a PORT or EPRT
+// command asks the peer to connect back to.
+func parseActiveCommand(line string) (addr string, ok bool) {
+	if m := portCommandPattern.FindStringSubmatch(line); m != nil {
+		a, err := parsePASVStyleAddr(m[1])
+		return a, err == nil
+	}
+	if m := eprtCommandPattern.FindStringSubmatch(line); m != nil {
+		return net.JoinHostPort(m[1], m[2]), true
+	}
+	return "", false
+}
+
+// rewriteActiveCommand replaces the address embedded in a PORT/EPRT command
+// with host:port, preserving which form the client used.
+func rewriteActiveCommand(line, host string, port int) (string, error) {
+	if portCommandPattern.MatchString(line) {
+		enc, err := formatPASVStyleAddr(host, port)
+		if err != nil {
+			return "", err
+		}
+		return portCommandPattern.ReplaceAllString(line, "PORT "+enc), nil
+	}
+	if eprtCommandPattern.MatchString(line) {
+		return eprtCommandPattern.ReplaceAllString(line, fmt.Sprintf("EPRT |1|%s|%d|", host, port)), nil
+	}
+	return "", fmt.Errorf("ftp: not an active command")
+}
+
+// splitLineEnding separates a line's trailing \r\n or \n (FTP control lines
+// may use either) from its content, so a rewrite can reattach the same
+// ending it found.
+func splitLineEnding(line string) (content, ending string) {
+	if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], "\r\n"
+	}
+	if strings.HasSuffix(line, "\n") {
+		return line[:len(line)-1], "\n"
+	}
+	return line, ""
+}
+
+// copyFTPLines is like io.Copy but reassembles src into \n-terminated
+// lines, passing each through rewrite before writing the result to dst.
+// FTP control traffic is defined by RFC 959 to be CRLF-terminated lines, so
+// this is how active/passive handling inspects and doctors replies and
+// commands as they pass through without disturbing everything else.
+func copyFTPLines(dst io.Writer, src io.Reader, rewrite func(line string) string) error {
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(dst, rewrite(line)); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// proxyFTPControl proxies stream<->peerConn for one FTP control connection,
+// rewriting the stream->peerConn direction line by line via rewrite while
+// copying peerConn->stream verbatim. Client and server each rewrite the
+// opposite logical direction of the same control connection (see the
+// package doc comment above), but relative to their own stream/peerConn
+// pairing it's always this one direction that needs FTP awareness.
+func proxyFTPControl(stream *Stream, peerConn net.Conn, rewrite func(line string) string) error {
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, peerConn)
+		errs <- err
+	}()
+	go func() {
+		err := copyFTPLines(peerConn, stream, rewrite)
+		errs <- err
+	}()
+
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !isTeardownNoise(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	_ = peerConn.Close()
+	_ = stream.Close()
+	return firstErr
+}
+
+// localAddrHost returns the IP conn is locally bound to, as seen by its
+// peer — i.e. an address that peer has already proven it can reach, making
+// it the right address to advertise for a new shadow listener.
+func localAddrHost(conn net.Conn) string {
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return "127.0.0.1"
+}
+
+// remoteAddrHost returns the IP conn's peer is connecting from. It's used to
+// remember which host owns the control connection a shadow data listener
+// was opened on behalf of, so the listener can refuse a connection from
+// anyone else; see serveShadowDataChannel.
+func remoteAddrHost(conn net.Conn) string {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return ""
+}
+
+// openShadowDataListener opens an ephemeral TCP listener on advertiseHost
+// for one FTP data channel, returning it along with the host:port to
+// advertise to whichever peer needs to dial in.
+func openShadowDataListener(advertiseHost string) (ln net.Listener, host string, port int, err error) {
+	ln, err = net.Listen("tcp", net.JoinHostPort(advertiseHost, "0"))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	return ln, advertiseHost, addr.Port, nil
+}
+
+// shadowAcceptTimeout bounds how long a shadow data listener waits for the
+// real FTP client/server to dial in after a PASV/EPSV/PORT/EPRT rewrite. An
+// aborted or abandoned transfer (ABOR, a dropped control connection, a
+// client that never opens the data channel) would otherwise leak the
+// listener and its goroutine for the life of the process.
+const shadowAcceptTimeout = 60 * time.Second
+
+// serveShadowDataChannel accepts connections on ln — an FTP data channel
+// carries a single transfer — and bridges the first one from expectedPeer
+// to a new data stream opened for target, the real address learned from
+// the PASV/EPSV reply or PORT/EPRT command this listener stands in for.
+// Without this check, anyone able to guess or scan the ephemeral shadow
+// port could win the race to connect first and hijack the data channel —
+// the standard anti-hijacking mitigation for FTP passive-mode proxies is to
+// restrict the data connection to the same peer as the control connection.
+// A connection from anyone else is closed and the listener keeps waiting
+// for the real peer. If nothing from expectedPeer dials in within
+// shadowAcceptTimeout, ln is closed and abandoned.
+func serveShadowDataChannel(logger *logrus.Logger, sess *Session, ln net.Listener, target, expectedPeer string) {
+	defer ln.Close()
+	if tcpLn, ok := ln.(*net.TCPListener); ok {
+		_ = tcpLn.SetDeadline(time.Now().Add(shadowAcceptTimeout))
+	}
+	var conn net.Conn
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if remoteAddrHost(c) != expectedPeer {
+			logger.WithField("remote", c.RemoteAddr()).Warn("ftp: rejected shadow data connection from unexpected peer")
+			_ = c.Close()
+			continue
+		}
+		conn = c
+		break
+	}
+	stream, err := sess.OpenDataStream(target)
+	if err != nil {
+		logger.WithError(err).Debug("ftp: failed to open data stream")
+		_ = conn.Close()
+		return
+	}
+	if err := proxyStream(conn, stream); err != nil {
+		logger.WithError(err).Debug("ftp: data channel closed with error")
+	}
+}
+
+// acceptDataStream dials stream.Target() — the real data peer learned from
+// whichever FTP command/reply triggered this data channel — and bridges it
+// to stream. It handles the receiving end of a peer's OpenDataStream call,
+// regardless of which side (client or server) initiated it.
+func acceptDataStream(logger *logrus.Logger, timeout time.Duration, stream *Stream) {
+	conn, err := net.DialTimeout("tcp", stream.Target(), timeout)
+	if err != nil {
+		logger.WithError(err).WithField("target", stream.Target()).Debug("ftp: failed to dial data channel")
+		_ = stream.Close()
+		return
+	}
+	if err := proxyStream(conn, stream); err != nil {
+		logger.WithError(err).Debug("ftp: data channel closed with error")
+	}
+}
+
+// rewritePassiveLine inspects one line of the FTP server's replies for a
+// PASV/EPSV address. If found, it opens a shadow listener advertised in
+// its place and arranges for a connection from expectedPeer — the real FTP
+// client, identified by its address on the control connection — to be
+// bridged to a new data stream toward the real address. Any other line, or
+// a recognized-but-malformed one, is returned unchanged.
+func rewritePassiveLine(logger *logrus.Logger, sess *Session, advertiseHost, expectedPeer, line string) string {
+	trimmed, ending := splitLineEnding(line)
+	target, ok := parsePassiveReply(trimmed, advertiseHost)
+	if !ok {
+		return line
+	}
+	ln, host, port, err := openShadowDataListener(advertiseHost)
+	if err != nil {
+		logger.WithError(err).Debug("ftp: failed to open passive shadow listener")
+		return line
+	}
+	rewritten, err := rewritePassiveReply(trimmed, host, port)
+	if err != nil {
+		_ = ln.Close()
+		logger.WithError(err).Debug("ftp: failed to rewrite passive reply")
+		return line
+	}
+	go serveShadowDataChannel(logger, sess, ln, target, expectedPeer)
+	return rewritten + ending
+}
+
+// rewriteActiveLine is rewritePassiveLine's counterpart for PORT/EPRT
+// commands arriving over the stream in the other direction; expectedPeer is
+// the real backend server's address on the control connection.
+func rewriteActiveLine(logger *logrus.Logger, sess *Session, advertiseHost, expectedPeer, line string) string {
+	trimmed, ending := splitLineEnding(line)
+	target, ok := parseActiveCommand(trimmed)
+	if !ok {
+		return line
+	}
+	ln, host, port, err := openShadowDataListener(advertiseHost)
+	if err != nil {
+		logger.WithError(err).Debug("ftp: failed to open active shadow listener")
+		return line
+	}
+	rewritten, err := rewriteActiveCommand(trimmed, host, port)
+	if err != nil {
+		_ = ln.Close()
+		logger.WithError(err).Debug("ftp: failed to rewrite active command")
+		return line
+	}
+	go serveShadowDataChannel(logger, sess, ln, target, expectedPeer)
+	return rewritten + ending
+}
+
+// proxyFTPControlClient proxies a single FTP control connection between
+// localConn and stream, rewriting the server's PASV/EPSV replies so the
+// real FTP client connects back to a local shadow listener instead of
+// dialing the real backend address directly (which it usually can't reach
+// across the tunnel). PORT/EPRT commands pass through unmodified — the
+// tunnel server rewrites those; see proxyFTPControlServer.
+func proxyFTPControlClient(logger *logrus.Logger, localConn net.Conn, sess *Session, stream *Stream) error {
+	advertiseHost := localAddrHost(localConn)
+	expectedPeer := remoteAddrHost(localConn)
+	return proxyFTPControl(stream, localConn, func(line string) string {
+		return rewritePassiveLine(logger, sess, advertiseHost, expectedPeer, line)
+	})
+}
+
+// proxyFTPControlServer is proxyFTPControlClient's counterpart: it
+// rewrites PORT/EPRT commands in the client->backend direction so the real
+// backend server connects back to a local shadow listener, and passes
+// PASV/EPSV replies through unmodified.
+func proxyFTPControlServer(logger *logrus.Logger, stream *Stream, ftpConn net.Conn) error {
+	advertiseHost := localAddrHost(ftpConn)
+	expectedPeer := remoteAddrHost(ftpConn)
+	return proxyFTPControl(stream, ftpConn, func(line string) string {
+		return rewriteActiveLine(logger, stream.session, advertiseHost, expectedPeer, line)
+	})
+}
@@ -0,0 +1,263 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParsePassiveReplyPASV(t *testing.T) {
+	addr, ok := parsePassiveReply("227 Entering Passive Mode (10,0,0,5,200,22).", "")
+	if !ok {
+		t.Fatal("expected PASV reply to parse")
+	}
+	if addr != "10.0.0.5:51222" {
+		t.Fatalf("expected 10.0.0.5:51222, got %q", addr)
+	}
+}
+
+func TestParsePassiveReplyEPSV(t *testing.T) {
+	addr, ok := parsePassiveReply("229 Entering Extended Passive Mode (|||48620|).", "10.0.0.5")
+	if !ok {
+		t.Fatal("expected EPSV reply to parse")
+	}
+	if addr != "10.0.0.5:48620" {
+		t.Fatalf("expected 10.0.0.5:48620, got %q", addr)
+	}
+}
+
+func TestParsePassiveReplyIgnoresOtherLines(t *testing.T) {
+	if _, ok := parsePassiveReply("150 Opening data connection.", ""); ok {
+		t.Fatal("expected non-passive reply to be ignored")
+	}
+}
+
+func TestRewritePassiveReplyPreservesForm(t *testing.T) {
+	rewritten, err := rewritePassiveReply("227 Entering Passive Mode (10,0,0,5,200,22).", "127.0.0.1", 4096)
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if addr, ok := parsePassiveReply(rewritten, ""); !ok || addr != "127.0.0.1:4096" {
+		t.Fatalf("expected rewritten reply to parse back to 127.0.0.1:4096, got %q (ok=%v)", addr, ok)
+	}
+
+	rewritten, err = rewritePassiveReply("229 Entering Extended Passive Mode (|||48620|).", "127.0.0.1", 4096)
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if addr, ok := parsePassiveReply(rewritten, "127.0.0.1"); !ok || addr != "127.0.0.1:4096" {
+		t.Fatalf("expected rewritten EPSV reply to parse back to 127.0.0.1:4096, got %q (ok=%v)", addr, ok)
+	}
+}
+
+func TestParseActiveCommandPORT(t *testing.T) {
+	addr, ok := parseActiveCommand("PORT 10,0,0,5,200,22")
+	if !ok {
+		t.Fatal("expected PORT command to parse")
+	}
+	if addr != "10.0.0.5:51222" {
+		t.Fatalf("expected 10.0.0.5:51222, got %q", addr)
+	}
+}
+
+func TestParseActiveCommandEPRT(t *testing.T) {
+	addr, ok := parseActiveCommand("EPRT |1|10.0.0.5|51222|")
+	if !ok {
+		t.Fatal("expected EPRT command to parse")
+	}
+	if addr != "10.0.0.5:51222" {
+		t.Fatalf("expected 10.0.0.5:51222, got %q", addr)
+	}
+}
+
+func TestRewriteActiveCommandPreservesForm(t *testing.T) {
+	rewritten, err := rewriteActiveCommand("PORT 10,0,0,5,200,22", "127.0.0.1", 4096)
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if addr, ok := parseActiveCommand(rewritten); !ok || addr != "127.0.0.1:4096" {
+		t.Fatalf("expected rewritten command to parse back to 127.0.0.1:4096, got %q (ok=%v)", addr, ok)
+	}
+
+	rewritten, err = rewriteActiveCommand("EPRT |1|10.0.0.5|51222|", "127.0.0.1", 4096)
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if addr, ok := parseActiveCommand(rewritten); !ok || addr != "127.0.0.1:4096" {
+		t.Fatalf("expected rewritten EPRT command to parse back to 127.0.0.1:4096, got %q (ok=%v)", addr, ok)
+	}
+}
+
+func TestCopyFTPLinesRewritesOnlyMatchedLines(t *testing.T) {
+	src := strings.NewReader("150 Opening data connection.\r\n227 Entering Passive Mode (10,0,0,5,200,22).\r\n")
+	var buf bytes.Buffer
+	err := copyFTPLines(&buf, src, func(line string) string {
+		if _, ok := parsePassiveReply(line, ""); ok {
+			return "REWRITTEN\r\n"
+		}
+		return line
+	})
+	if err != nil {
+		t.Fatalf("copyFTPLines: %v", err)
+	}
+	want := "150 Opening data connection.\r\nREWRITTEN\r\n"
+	if buf.String() != want {
+		t.Fatalf("expected %q, got %q", want, buf.String())
+	}
+}
+
+// TestSessionOpenDataStreamCarriesTarget verifies that OpenDataStream's
+// target reaches the peer's onOpen callback, which is how the receiving
+// side of an active/passive data channel learns where to dial; see
+// acceptDataStream.
+func TestSessionOpenDataStreamCarriesTarget(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	var gotTarget string
+	done := make(chan struct{})
+	server := NewSession(serverConn, 0, 1, func(st *Stream) {
+		gotTarget = st.Target()
+		close(done)
+	})
+	defer server.Close(nil)
+
+	client := NewSession(clientConn, 0, 0, nil)
+	defer client.Close(nil)
+
+	stream, err := client.OpenDataStream("10.0.0.5:51222")
+	if err != nil {
+		t.Fatalf("open data stream: %v", err)
+	}
+	defer stream.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for server to accept data stream")
+	}
+	if gotTarget != "10.0.0.5:51222" {
+		t.Fatalf("expected target 10.0.0.5:51222, got %q", gotTarget)
+	}
+}
+
+// TestServeShadowDataChannelBridgesToTarget exercises the passive-mode data
+// path end to end without a full FTP control connection: a fake "real FTP
+// data backend" listens on realTarget, serveShadowDataChannel is pointed at
+// it via a client/server Session pair, and a connection to the shadow
+// listener should come out the other side at the backend.
+func TestServeShadowDataChannelBridgesToTarget(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen backend: %v", err)
+	}
+	defer backendLn.Close()
+
+	backendConns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := backendLn.Accept()
+		if err == nil {
+			backendConns <- conn
+		}
+	}()
+
+	clientConn, serverConn := net.Pipe()
+	client := NewSession(clientConn, 0, 0, nil)
+	defer client.Close(nil)
+
+	server := NewSession(serverConn, 0, 1, func(st *Stream) {
+		go acceptDataStream(logrus.New(), time.Second, st)
+	})
+	defer server.Close(nil)
+
+	shadowLn, host, port, err := openShadowDataListener("127.0.0.1")
+	if err != nil {
+		t.Fatalf("open shadow listener: %v", err)
+	}
+	go serveShadowDataChannel(logrus.New(), client, shadowLn, backendLn.Addr().String(), "127.0.0.1")
+
+	dialConn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("dial shadow listener: %v", err)
+	}
+	defer dialConn.Close()
+
+	if _, err := dialConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case backendConn := <-backendConns:
+		defer backendConn.Close()
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(backendConn, buf); err != nil {
+			t.Fatalf("read at backend: %v", err)
+		}
+		if string(buf) != "hello" {
+			t.Fatalf("expected \"hello\" at backend, got %q", buf)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for backend to receive the data connection")
+	}
+}
+
+// TestServeShadowDataChannelRejectsUnexpectedPeer simulates an attacker
+// racing the real FTP client/server to the shadow data listener: it dials
+// in from 127.0.0.1 while serveShadowDataChannel was told to expect a peer
+// at a different address, and asserts the connection is dropped rather than
+// bridged to the backend.
+func TestServeShadowDataChannelRejectsUnexpectedPeer(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen backend: %v", err)
+	}
+	defer backendLn.Close()
+
+	backendConns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := backendLn.Accept()
+		if err == nil {
+			backendConns <- conn
+		}
+	}()
+
+	clientConn, serverConn := net.Pipe()
+	client := NewSession(clientConn, 0, 0, nil)
+	defer client.Close(nil)
+
+	server := NewSession(serverConn, 0, 1, func(st *Stream) {
+		go acceptDataStream(logrus.New(), time.Second, st)
+	})
+	defer server.Close(nil)
+
+	shadowLn, host, port, err := openShadowDataListener("127.0.0.1")
+	if err != nil {
+		t.Fatalf("open shadow listener: %v", err)
+	}
+	go serveShadowDataChannel(logrus.New(), client, shadowLn, backendLn.Addr().String(), "10.0.0.9")
+
+	dialConn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("dial shadow listener: %v", err)
+	}
+	defer dialConn.Close()
+	dialConn.Write([]byte("hijack"))
+
+	select {
+	case backendConn := <-backendConns:
+		backendConn.Close()
+		t.Fatal("expected connection from unexpected peer to be rejected, but it reached the backend")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	buf := make([]byte, 1)
+	_ = dialConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := dialConn.Read(buf); err == nil {
+		t.Fatal("expected the rejected connection to be closed by the listener")
+	}
+}
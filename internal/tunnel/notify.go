@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/njangra/falcon-tunnel/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier receives tunnel connection lifecycle events, inspired by
+// sftpgo's notifier-plugin hooks. Implementations run synchronously on the
+// goroutine raising the event: the connection's own goroutine, except for
+// the server's OnConnect, which runs in its accept loop. A slow notifier
+// (e.g. a webhook to an unreachable host) therefore delays that connection,
+// or for the server's OnConnect every later accept; WebhookNotifier sends
+// in its own goroutine for this reason. Built-in implementations are
+// LogrusNotifier and WebhookNotifier; callers can supply their own for SIEM
+// export, Slack alerts, or similar integrations without patching this
+// package.
+type Notifier interface {
+	// OnConnect fires once a tunnel connection is accepted, before
+	// authentication.
+	OnConnect(remote string)
+	// OnAuthSuccess fires after a successful auth handshake.
+	OnAuthSuccess(remote, username string)
+	// OnAuthFailure fires after a rejected or errored auth handshake.
+	OnAuthFailure(remote string, reason error)
+	// OnDisconnect fires once a connection's proxy loop ends, reporting the
+	// bytes copied in each direction and how long the connection lasted.
+	OnDisconnect(remote, username string, bytesIn, bytesOut uint64, duration time.Duration)
+}
+
+// notifyAll calls fn for every configured notifier. Server and Client keep
+// a slice rather than a single Notifier so a deployment can, say, log
+// locally and also forward to a webhook.
+func notifyAll(notifiers []Notifier, fn func(Notifier)) {
+	for _, n := range notifiers {
+		fn(n)
+	}
+}
+
+// LogrusNotifier implements Notifier by writing structured log lines via a
+// *logrus.Logger. It is the default notifier when none is configured, so
+// logging behavior without any Notifiers config is unchanged.
+type LogrusNotifier struct {
+	Logger *logrus.Logger
+}
+
+// NewLogrusNotifier returns a LogrusNotifier writing to logger (or a new
+// default logger if nil).
+func NewLogrusNotifier(logger *logrus.Logger) *LogrusNotifier {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	return &LogrusNotifier{Logger: logger}
+}
+
+func (n *LogrusNotifier) OnConnect(remote string) {
+	n.Logger.WithField("remote", remote).Debug("tunnel connection accepted")
+}
+
+func (n *LogrusNotifier) OnAuthSuccess(remote, username string) {
+	fields := logrus.Fields{"remote": remote}
+	if username != "" {
+		fields["user"] = username
+	}
+	n.Logger.WithFields(fields).Info("authentication succeeded")
+}
+
+func (n *LogrusNotifier) OnAuthFailure(remote string, reason error) {
+	n.Logger.WithField("remote", remote).WithError(reason).Warn("authentication failed")
+}
+
+func (n *LogrusNotifier) OnDisconnect(remote, username string, bytesIn, bytesOut uint64, duration time.Duration) {
+	fields := logrus.Fields{
+		"remote":    remote,
+		"bytes_in":  bytesIn,
+		"bytes_out": bytesOut,
+		"duration":  duration.String(),
+	}
+	if username != "" {
+		fields["user"] = username
+	}
+	n.Logger.WithFields(fields).Info("tunnel connection closed")
+}
+
+// webhookEvent is the JSON body WebhookNotifier POSTs for every event.
+type webhookEvent struct {
+	Type     string `json:"type"`
+	Remote   string `json:"remote"`
+	Username string `json:"username,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	BytesIn  uint64 `json:"bytes_in,omitempty"`
+	BytesOut uint64 `json:"bytes_out,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// WebhookNotifier implements Notifier by POSTing a JSON webhookEvent to URL.
+// When Secret is non-empty, the request carries an X-Falcon-Signature
+// header: the hex-encoded HMAC-SHA256 of the raw JSON body, keyed by
+// Secret, so the receiver can verify the event came from this server.
+// Requests are sent from their own goroutine so a slow or unreachable
+// endpoint doesn't delay the connection whose event triggered it.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+	// Logger, if set, receives a warning when a webhook delivery fails.
+	Logger *logrus.Logger
+}
+
+// NewWebhookNotifier returns a WebhookNotifier posting to url, signing with
+// secret if non-empty.
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Secret: secret, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) OnConnect(remote string) {
+	n.send(webhookEvent{Type: "connect", Remote: remote})
+}
+
+func (n *WebhookNotifier) OnAuthSuccess(remote, username string) {
+	n.send(webhookEvent{Type: "auth_success", Remote: remote, Username: username})
+}
+
+func (n *WebhookNotifier) OnAuthFailure(remote string, reason error) {
+	event := webhookEvent{Type: "auth_failure", Remote: remote}
+	if reason != nil {
+		event.Reason = reason.Error()
+	}
+	n.send(event)
+}
+
+func (n *WebhookNotifier) OnDisconnect(remote, username string, bytesIn, bytesOut uint64, duration time.Duration) {
+	n.send(webhookEvent{
+		Type:     "disconnect",
+		Remote:   remote,
+		Username: username,
+		BytesIn:  bytesIn,
+		BytesOut: bytesOut,
+		Duration: duration.String(),
+	})
+}
+
+func (n *WebhookNotifier) send(event webhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	go func() {
+		if err := n.post(body); err != nil && n.Logger != nil {
+			n.Logger.WithError(err).WithField("url", n.URL).Warn("webhook notifier delivery failed")
+		}
+	}()
+}
+
+func (n *WebhookNotifier) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Falcon-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// BuildNotifiers converts config.NotifierConfig entries into Notifiers.
+// cfgs supports only the "webhook" type. The result holds only the
+// configured notifiers and no LogrusNotifier, so passing a non-empty result
+// to NewServer/NewClient replaces their default LogrusNotifier.
+func BuildNotifiers(cfgs []config.NotifierConfig, logger *logrus.Logger) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0, len(cfgs))
+	for i, c := range cfgs {
+		switch c.Type {
+		case "webhook":
+			if c.URL == "" {
+				return nil, fmt.Errorf("notifiers[%d]: webhook requires a url", i)
+			}
+			webhook := NewWebhookNotifier(c.URL, c.Secret)
+			webhook.Logger = logger
+			notifiers = append(notifiers, webhook)
+		default:
+			return nil, fmt.Errorf("notifiers[%d]: unsupported type %q", i, c.Type)
+		}
+	}
+	return notifiers, nil
+}
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/njangra/falcon-tunnel/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogrusNotifierDoesNotPanic(t *testing.T) {
+	n := NewLogrusNotifier(nil)
+	n.OnConnect("127.0.0.1:1234")
+	n.OnAuthSuccess("127.0.0.1:1234", "alice")
+	n.OnAuthFailure("127.0.0.1:1234", io.ErrUnexpectedEOF)
+	n.OnDisconnect("127.0.0.1:1234", "alice", 10, 20, time.Second)
+}
+
+func TestWebhookNotifierSignsAndPostsEvent(t *testing.T) {
+	const secret = "s3cr3t"
+	received := make(chan webhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		want := hex.EncodeToString(mac.Sum(nil))
+		if got := r.Header.Get("X-Falcon-Signature"); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var event webhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("unmarshal event: %v", err)
+			return
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	n := NewWebhookNotifier(srv.URL, secret)
+	n.OnAuthSuccess("127.0.0.1:1234", "alice")
+
+	select {
+	case event := <-received:
+		if event.Type != "auth_success" || event.Username != "alice" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+}
+
+func TestWebhookNotifierLogsFailedDelivery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	hook := &testHook{done: make(chan struct{}, 1)}
+	logger.AddHook(hook)
+
+	n := NewWebhookNotifier(srv.URL, "")
+	n.Logger = logger
+	n.OnConnect("127.0.0.1:1234")
+
+	select {
+	case <-hook.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for failure log")
+	}
+}
+
+func TestBuildNotifiersRejectsUnknownType(t *testing.T) {
+	_, err := BuildNotifiers([]config.NotifierConfig{{Type: "carrier-pigeon"}}, nil)
+	if err == nil {
+		t.Fatal("expected error for unsupported notifier type")
+	}
+}
+
+func TestBuildNotifiersRejectsMissingWebhookURL(t *testing.T) {
+	_, err := BuildNotifiers([]config.NotifierConfig{{Type: "webhook"}}, nil)
+	if err == nil {
+		t.Fatal("expected error for webhook without url")
+	}
+}
+
+func TestBuildNotifiersConstructsWebhook(t *testing.T) {
+	notifiers, err := BuildNotifiers([]config.NotifierConfig{{Type: "webhook", URL: "http://example.invalid", Secret: "x"}}, nil)
+	if err != nil {
+		t.Fatalf("BuildNotifiers: %v", err)
+	}
+	if len(notifiers) != 1 {
+		t.Fatalf("len(notifiers) = %d, want 1", len(notifiers))
+	}
+	if _, ok := notifiers[0].(*WebhookNotifier); !ok {
+		t.Fatalf("notifiers[0] = %T, want *WebhookNotifier", notifiers[0])
+	}
+}
+
+// testHook is a minimal logrus.Hook that signals done when it fires.
+type testHook struct {
+	done chan struct{}
+}
+
+func (h *testHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *testHook) Fire(*logrus.Entry) error {
+	select {
+	case h.done <- struct{}{}:
+	default:
+	}
+	return nil
+}
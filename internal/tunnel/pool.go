@@ -3,30 +3,63 @@ package tunnel
 import (
 	"context"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// connPool is a lightweight semaphore-controlled dialer enforcing max concurrent FTP connections.
-// Connections are not reused; this keeps behavior predictable while enforcing resource limits.
+// connPool is a semaphore-bounded dialer that reuses idle FTP connections.
+// Idle connections are kept on a LIFO stack (most recently released conns
+// are handed out first, since they're least likely to have gone stale) and
+// validated with a peek read before being returned from Acquire. A janitor
+// goroutine evicts and closes idle connections older than keepAlive.
 type connPool struct {
 	target    string
 	timeout   time.Duration
 	keepAlive time.Duration
+	maxSize   int
 	sem       chan struct{}
+
+	mu   sync.Mutex
+	idle []idleConn
+
+	dialErrors uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type idleConn struct {
+	conn     net.Conn
+	lastUsed time.Time
+}
+
+// PoolMetrics is a point-in-time snapshot of connPool state.
+type PoolMetrics struct {
+	InUse      int
+	Idle       int
+	DialErrors uint64
 }
 
 func newConnPool(target string, timeout, keepAlive time.Duration, maxSize int) *connPool {
 	if maxSize <= 0 {
 		maxSize = 1
 	}
-	return &connPool{
+	p := &connPool{
 		target:    target,
 		timeout:   timeout,
 		keepAlive: keepAlive,
+		maxSize:   maxSize,
 		sem:       make(chan struct{}, maxSize),
+		stop:      make(chan struct{}),
 	}
+	p.wg.Add(1)
+	go p.janitor()
+	return p
 }
 
+// Acquire returns an idle, still-live connection if one is available,
+// otherwise dials a fresh one. It blocks until a slot is free or ctx is done.
 func (p *connPool) Acquire(ctx context.Context) (net.Conn, error) {
 	select {
 	case p.sem <- struct{}{}:
@@ -34,24 +67,85 @@ func (p *connPool) Acquire(ctx context.Context) (net.Conn, error) {
 		return nil, ctx.Err()
 	}
 
+	if conn := p.popLiveIdle(); conn != nil {
+		return conn, nil
+	}
+
 	dialer := &net.Dialer{Timeout: p.timeout, KeepAlive: p.keepAlive}
 	conn, err := dialer.DialContext(ctx, "tcp", p.target)
 	if err != nil {
+		atomic.AddUint64(&p.dialErrors, 1)
 		p.releaseToken()
 		return nil, err
 	}
 	return conn, nil
 }
 
-func (p *connPool) Release(conn net.Conn, keep bool) {
-	if conn != nil {
-		if !keep {
-			_ = conn.Close()
-		} else {
-			_ = conn.Close() // close on release; reuse not implemented
+// popLiveIdle pops connections off the idle stack, discarding any that fail
+// liveness validation, until it finds a usable one or the stack is empty.
+func (p *connPool) popLiveIdle() net.Conn {
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if isConnAlive(c.conn) {
+			return c.conn
 		}
+		_ = c.conn.Close()
+	}
+}
+
+// isConnAlive peeks the connection with an already-elapsed read deadline: a
+// timeout error means nothing arrived, i.e. the peer is still there and
+// quiet; EOF or any other error means the peer closed or reset the
+// connection. Any unexpected data means the connection is out of sync with
+// protocol expectations, so it's treated as dead too.
+func isConnAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now()); err != nil {
+		return false
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1)
+	_, err := conn.Read(buf)
+	if err == nil {
+		return false
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return false
+}
+
+// Release returns conn to the pool for reuse when keep is true and the pool
+// isn't full; otherwise it closes conn. Either way it frees the Acquire slot.
+func (p *connPool) Release(conn net.Conn, keep bool) {
+	defer p.releaseToken()
+	if conn == nil {
+		return
+	}
+	if !keep {
+		_ = conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	full := len(p.idle) >= p.maxSize
+	if !full {
+		p.idle = append(p.idle, idleConn{conn: conn, lastUsed: time.Now()})
+	}
+	p.mu.Unlock()
+
+	if full {
+		_ = conn.Close()
 	}
-	p.releaseToken()
 }
 
 func (p *connPool) releaseToken() {
@@ -61,7 +155,76 @@ func (p *connPool) releaseToken() {
 	}
 }
 
+// janitor periodically evicts idle connections that have outlived keepAlive.
+func (p *connPool) janitor() {
+	defer p.wg.Done()
+	interval := p.keepAlive
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			p.evictStale()
+		}
+	}
+}
+
+func (p *connPool) evictStale() {
+	if p.keepAlive <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-p.keepAlive)
+
+	p.mu.Lock()
+	fresh := p.idle[:0]
+	var stale []net.Conn
+	for _, c := range p.idle {
+		if c.lastUsed.Before(cutoff) {
+			stale = append(stale, c.conn)
+		} else {
+			fresh = append(fresh, c)
+		}
+	}
+	p.idle = fresh
+	p.mu.Unlock()
+
+	for _, c := range stale {
+		_ = c.Close()
+	}
+}
+
+// Metrics returns a snapshot of pool state for logging/monitoring.
+func (p *connPool) Metrics() PoolMetrics {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+	return PoolMetrics{
+		InUse:      len(p.sem),
+		Idle:       idle,
+		DialErrors: atomic.LoadUint64(&p.dialErrors),
+	}
+}
+
+// Close stops the janitor, closes all idle connections and frees every
+// Acquire slot. Connections currently handed out by Acquire are not tracked
+// and are not closed.
 func (p *connPool) Close() {
+	close(p.stop)
+	p.wg.Wait()
+
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, c := range idle {
+		_ = c.conn.Close()
+	}
+
 	for {
 		select {
 		case <-p.sem:
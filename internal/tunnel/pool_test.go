@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnPoolReusesReleasedConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go acceptAndEcho(ln)
+
+	p := newConnPool(ln.Addr().String(), time.Second, time.Minute, 2)
+	defer p.Close()
+
+	ctx := context.Background()
+	c1, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	p.Release(c1, true)
+
+	if m := p.Metrics(); m.Idle != 1 {
+		t.Fatalf("expected 1 idle connection, got %d", m.Idle)
+	}
+
+	c2, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if c2 != c1 {
+		t.Fatalf("expected reused connection, got a new one")
+	}
+	p.Release(c2, false)
+
+	if m := p.Metrics(); m.Idle != 0 {
+		t.Fatalf("expected connection closed rather than pooled, got idle=%d", m.Idle)
+	}
+}
+
+func TestConnPoolDiscardsDeadIdleConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go acceptAndEcho(ln)
+
+	p := newConnPool(ln.Addr().String(), time.Second, time.Minute, 2)
+	defer p.Close()
+
+	ctx := context.Background()
+	c1, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	_ = c1.Close() // simulate the backend closing the connection while idle
+	p.Release(c1, true)
+
+	c2, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if c2 == c1 {
+		t.Fatalf("expected dead connection to be discarded, not reused")
+	}
+	p.Release(c2, false)
+}
+
+func TestConnPoolJanitorEvictsStaleIdleConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go acceptAndEcho(ln)
+
+	p := newConnPool(ln.Addr().String(), time.Second, 20*time.Millisecond, 2)
+	defer p.Close()
+
+	c1, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	p.Release(c1, true)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if p.Metrics().Idle == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected janitor to evict stale idle connection")
+}
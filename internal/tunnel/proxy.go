@@ -36,6 +36,89 @@ func proxyWithIdle(a, b net.Conn, idle time.Duration) error {
 	return firstErr
 }
 
+// proxyWithIdleResumable behaves like proxyWithIdle, but tees each direction
+// through a ringBuffer as it copies, so bytes are retained for replay if the
+// connection drops and the peer later resumes. sentToA records what was
+// copied from b to a; recvFromA records what was copied from a to b.
+//
+// a is the tunnel-facing conn and is always disposable: it is closed as soon
+// as either copy direction ends, which also unblocks a still-pending read on
+// a for whichever goroutine hadn't finished yet. b is the backend FTP conn,
+// which the caller may want to keep alive for a resumed session, so it is
+// never closed here — a pending read on b is instead interrupted with a
+// deadline, which is cleared again before returning so the caller gets back
+// a conn usable for further reads and writes.
+func proxyWithIdleResumable(a, b net.Conn, idle time.Duration, sentToA, recvFromA *ringBuffer) error {
+	errs := make(chan error, 2)
+	stop := make(chan struct{})
+
+	go refreshDeadline(a, idle, stop)
+	go refreshDeadline(b, idle, stop)
+
+	go func() {
+		_, err := io.Copy(a, io.TeeReader(b, ringWriter{sentToA}))
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(b, io.TeeReader(a, ringWriter{recvFromA}))
+		errs <- err
+	}()
+
+	firstErr := <-errs
+	close(stop)
+	_ = a.Close()
+	_ = b.SetReadDeadline(time.Now())
+	if secondErr := <-errs; firstErr == nil {
+		firstErr = secondErr
+	}
+	_ = b.SetReadDeadline(time.Time{})
+
+	if isTeardownNoise(firstErr) {
+		firstErr = nil
+	}
+	return firstErr
+}
+
+// isTeardownNoise reports whether err is just the side effect of this
+// package's own connection teardown (closing a conn a blocked read was
+// waiting on, or interrupting one with a deadline) rather than a genuine
+// transport failure worth surfacing to the caller.
+func isTeardownNoise(err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// proxyStream copies data between a local net.Conn and a multiplexed Stream.
+// Liveness is handled by the Session's heartbeat rather than per-copy
+// deadline refresh, since a Stream has no deadline of its own.
+func proxyStream(local net.Conn, stream *Stream) error {
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, local)
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(local, stream)
+		errs <- err
+	}()
+
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil && firstErr == nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+			firstErr = err
+		}
+	}
+	_ = local.Close()
+	_ = stream.Close()
+	return firstErr
+}
+
 func refreshDeadline(c net.Conn, idle time.Duration, stop <-chan struct{}) {
 	if idle <= 0 {
 		return
@@ -0,0 +1,180 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// resumeBufferSize is how much recently-transferred data each direction
+// retains for replay after a reconnect.
+const resumeBufferSize = 256 * 1024
+
+// resumeGrace is how long a dropped single-stream connection's backend FTP
+// conn and byte buffers are kept around waiting for the client to resume
+// before they are released like any other drop.
+const resumeGrace = 30 * time.Second
+
+// resumeStashWait bounds how long an incoming MsgResume waits for the
+// dropped connection it's reattaching to finish being stashed, covering the
+// race where a client redials faster than the server notices the drop.
+const resumeStashWait = 250 * time.Millisecond
+
+// ringBuffer retains the most recent resumeBufferSize bytes written to it,
+// addressed by an ever-increasing absolute offset, so a reconnecting peer
+// can ask for "everything since offset N" without the buffer growing
+// unbounded.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	base uint64 // absolute offset of buf[0]; bytes before this have been evicted
+}
+
+func newRingBuffer() *ringBuffer {
+	return &ringBuffer{}
+}
+
+// Write appends p, evicting the oldest bytes once the buffer exceeds
+// resumeBufferSize.
+func (r *ringBuffer) Write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append(r.buf, p...)
+	if excess := len(r.buf) - resumeBufferSize; excess > 0 {
+		r.buf = append([]byte(nil), r.buf[excess:]...)
+		r.base += uint64(excess)
+	}
+}
+
+// Since returns the bytes written from absolute offset onward. ok is false
+// if offset predates what the buffer still retains, or is ahead of what has
+// actually been written.
+func (r *ringBuffer) Since(offset uint64) (data []byte, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	end := r.base + uint64(len(r.buf))
+	if offset < r.base || offset > end {
+		return nil, false
+	}
+	out := make([]byte, end-offset)
+	copy(out, r.buf[offset-r.base:])
+	return out, true
+}
+
+// End reports the absolute offset just past the last byte written.
+func (r *ringBuffer) End() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.base + uint64(len(r.buf))
+}
+
+// ringWriter adapts a ringBuffer to io.Writer so it can sit behind an
+// io.TeeReader in proxyWithIdleResumable.
+type ringWriter struct{ buf *ringBuffer }
+
+func (w ringWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return len(p), nil
+}
+
+// encodeAck packs a MsgAck payload: a 1-byte resumed flag followed by an
+// 8-byte big-endian byte offset.
+func encodeAck(offset uint64, resumed bool) []byte {
+	buf := make([]byte, 9)
+	if resumed {
+		buf[0] = 1
+	}
+	binary.BigEndian.PutUint64(buf[1:], offset)
+	return buf
+}
+
+// decodeAck reverses encodeAck.
+func decodeAck(payload []byte) (offset uint64, resumed bool, ok bool) {
+	if len(payload) != 9 {
+		return 0, false, false
+	}
+	return binary.BigEndian.Uint64(payload[1:]), payload[0] == 1, true
+}
+
+// resumableConn is a single-stream backend FTP connection kept alive, along
+// with the bytes recently sent and received over the (now dropped) tunnel
+// connection, so a client that resumes within resumeGrace can pick the
+// transfer back up without restarting it.
+type resumableConn struct {
+	ftpConn net.Conn
+	sentBuf *ringBuffer // bytes forwarded from ftpConn to the tunnel connection
+	recvBuf *ringBuffer // bytes forwarded from the tunnel connection to ftpConn
+	timer   *time.Timer
+}
+
+// stashResumable holds ftpConn and its buffers under token until either
+// takeResumable claims them or resumeGrace elapses, whichever comes first.
+func (s *Server) stashResumable(token string, ftpConn net.Conn, sentBuf, recvBuf *ringBuffer) {
+	s.resumeMu.Lock()
+	defer s.resumeMu.Unlock()
+	if s.resumable == nil {
+		s.resumable = make(map[string]*resumableConn)
+	}
+	s.resumable[token] = &resumableConn{
+		ftpConn: ftpConn,
+		sentBuf: sentBuf,
+		recvBuf: recvBuf,
+		timer:   time.AfterFunc(resumeGrace, func() { s.expireResumable(token) }),
+	}
+}
+
+// takeResumable removes and returns the resumable state stashed under token,
+// if any is still waiting.
+func (s *Server) takeResumable(token string) (*resumableConn, bool) {
+	s.resumeMu.Lock()
+	defer s.resumeMu.Unlock()
+	pending, ok := s.resumable[token]
+	if !ok {
+		return nil, false
+	}
+	pending.timer.Stop()
+	delete(s.resumable, token)
+	return pending, true
+}
+
+// resumeStashPoll is how often takeResumableWait rechecks for a stash that
+// hasn't landed yet.
+const resumeStashPoll = 5 * time.Millisecond
+
+// takeResumableWait is takeResumable with a short grace window for the
+// common race where a client redials and presents its token before the
+// dropped connection's own handler has finished detecting the drop and
+// calling stashResumable. Real network round trips make that window rare in
+// practice, but it's cheap to wait out rather than needlessly falling back
+// to a fresh backend dial.
+func (s *Server) takeResumableWait(token string, wait time.Duration) (*resumableConn, bool) {
+	deadline := time.Now().Add(wait)
+	for {
+		if pending, ok := s.takeResumable(token); ok {
+			return pending, true
+		}
+		if time.Now().After(deadline) {
+			return nil, false
+		}
+		time.Sleep(resumeStashPoll)
+	}
+}
+
+// expireResumable releases a stashed connection whose grace period elapsed
+// without the client reconnecting.
+func (s *Server) expireResumable(token string) {
+	s.resumeMu.Lock()
+	pending, ok := s.resumable[token]
+	if ok {
+		delete(s.resumable, token)
+	}
+	s.resumeMu.Unlock()
+	if !ok {
+		return
+	}
+	s.pool.Release(pending.ftpConn, false)
+	if s.tokenStore != nil {
+		s.tokenStore.Delete(token)
+	}
+}
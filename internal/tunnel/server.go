@@ -2,22 +2,26 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/njangra/falcon-tunnel/internal/auth"
 	"github.com/njangra/falcon-tunnel/internal/config"
+	"github.com/njangra/falcon-tunnel/pkg/protocol"
 	"github.com/sirupsen/logrus"
 )
 
 // Server is a minimal TCP tunnel server that authenticates clients and proxies bytes to an FTP server.
 type Server struct {
 	cfg           config.Config
-	authenticator *auth.Authenticator
+	authenticator auth.Authenticator
+	tokenStore    auth.TokenStore
 	logger        *logrus.Logger
 
 	conns   map[uint64]net.Conn
@@ -26,28 +30,118 @@ type Server struct {
 	maxConn int
 	wg      sync.WaitGroup
 
+	// userConns counts live connections per authenticated username, guarded
+	// by connMu alongside conns, so a Principal's MaxConnections can be
+	// enforced independently of the server-wide maxConn cap.
+	userConns map[string]int
+
 	pool *connPool
+
+	// resumable holds the backend FTP connection and byte buffers for a
+	// single-stream tunnel connection that dropped, keyed by its
+	// resumption token, until a reconnect claims it via takeResumable or
+	// resumeGrace elapses. See stashResumable.
+	resumeMu  sync.Mutex
+	resumable map[string]*resumableConn
+
+	notifiers []Notifier
 }
 
-// NewServer constructs a Server.
-func NewServer(cfg config.Config, authenticator *auth.Authenticator, logger *logrus.Logger) *Server {
-	if authenticator == nil {
-		authenticator = auth.New(cfg.Auth.PasswordHash)
-	}
+// NewServer constructs a Server. notifiers, if given, replace the default
+// LogrusNotifier for connect/auth/disconnect events; see Notifier.
+func NewServer(cfg config.Config, authenticator auth.Authenticator, logger *logrus.Logger, notifiers ...Notifier) *Server {
 	if logger == nil {
 		logger = logrus.New()
 	}
+	if authenticator == nil {
+		a, err := buildAuthenticator(cfg.Auth)
+		if err != nil {
+			logger.WithError(err).Error("invalid auth configuration; all authentication will fail")
+			a = auth.DenyAll{}
+		}
+		authenticator = a
+	}
+	if len(notifiers) == 0 {
+		notifiers = []Notifier{NewLogrusNotifier(logger)}
+	}
 	pool := newConnPool(cfg.Server.FTPServerAddr, cfg.Server.Timeout, cfg.Server.IdleTimeout, cfg.Server.PoolSize)
+	var tokenStore auth.TokenStore
+	if cfg.Auth.Enabled {
+		tokenStore = auth.NewMemoryTokenStore()
+	}
 	return &Server{
 		cfg:           cfg,
 		authenticator: authenticator,
+		tokenStore:    tokenStore,
 		logger:        logger,
 		conns:         make(map[uint64]net.Conn),
 		maxConn:       cfg.Server.MaxConnections,
 		pool:          pool,
+		userConns:     make(map[string]int),
+		notifiers:     notifiers,
 	}
 }
 
+// verifyPeerAddress enforces cfg.Server's TLS.VerifyPeerAddress policy: if
+// set, it drives the (possibly still-pending) TLS handshake on c, bounded by
+// cfg.Server.Timeout so a client that never completes it can't block this
+// goroutine and its connection slot indefinitely, and checks the presented
+// client certificate's SANs against c's observed remote address, via
+// config.VerifyPeerAddress. A no-op for plaintext connections, connections
+// presenting no client certificate, or when the policy is off.
+func (s *Server) verifyPeerAddress(c net.Conn) error {
+	if !s.cfg.TLS.VerifyPeerAddress {
+		return nil
+	}
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if s.cfg.Server.Timeout > 0 {
+		_ = c.SetDeadline(time.Now().Add(s.cfg.Server.Timeout))
+		defer c.SetDeadline(time.Time{})
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+	peers := tlsConn.ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		return nil
+	}
+	return config.VerifyPeerAddress(peers[0], c.RemoteAddr(), s.cfg.TLS.AllowedClientSANs)
+}
+
+// PoolMetrics reports the current state of the backend FTP connection pool.
+func (s *Server) PoolMetrics() PoolMetrics {
+	return s.pool.Metrics()
+}
+
+// buildAuthenticator constructs the configured auth backend(s). A non-empty
+// Users list takes precedence, authenticating against per-user records
+// (password, source CIDRs, limits, roles); otherwise a non-empty Backends
+// list is chained; otherwise it falls back to the legacy single bcrypt
+// password_hash path.
+func buildAuthenticator(cfg config.AuthConfig) (auth.Authenticator, error) {
+	if len(cfg.Users) > 0 {
+		users := make([]auth.User, len(cfg.Users))
+		for i, u := range cfg.Users {
+			users[i] = auth.User{
+				Username:       u.Username,
+				PasswordHash:   u.PasswordHash,
+				AllowedCIDRs:   u.AllowedCIDRs,
+				IdleTimeout:    u.IdleTimeout,
+				MaxConnections: u.MaxConnections,
+				Roles:          u.Roles,
+			}
+		}
+		return auth.NewUserListAuth(users), nil
+	}
+	if len(cfg.Backends) > 0 {
+		return auth.NewChain(cfg.Backends)
+	}
+	return auth.NewBcryptAuth(cfg.PasswordHash), nil
+}
+
 // Serve begins accepting connections on the provided listener until ctx is cancelled.
 func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 	defer s.shutdown()
@@ -68,12 +162,22 @@ func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 			_ = conn.Close()
 			continue
 		}
+		notifyAll(s.notifiers, func(n Notifier) { n.OnConnect(conn.RemoteAddr().String()) })
 
 		s.wg.Add(1)
 		go func(c net.Conn) {
 			defer s.wg.Done()
 			defer s.unregisterConn(c)
-			if err := s.handleConn(ctx, c); err != nil {
+			if err := s.verifyPeerAddress(c); err != nil {
+				s.logger.WithError(err).WithField("remote", c.RemoteAddr().String()).Warn("rejecting connection: peer certificate address check failed")
+				_ = c.Close()
+				return
+			}
+			handle := s.handleConn
+			if s.cfg.Server.Multiplex {
+				handle = s.handleMultiplexedConn
+			}
+			if err := handle(ctx, c); err != nil {
 				s.logger.WithError(err).Debug("connection closed with error")
 			}
 		}(conn)
@@ -81,34 +185,237 @@ func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 }
 
 func (s *Server) handleConn(ctx context.Context, tunnelConn net.Conn) error {
+	remote := tunnelConn.RemoteAddr().String()
+	var token, resumedFrom string
+	var principal *auth.Principal
 	if s.cfg.Auth.Enabled {
-		if err := auth.HandshakeServer(tunnelConn, s.authenticator, s.cfg.Server.Timeout); err != nil {
+		p, issued, from, err := auth.HandshakeServer(tunnelConn, s.authenticator, s.tokenStore, s.cfg.Server.Timeout)
+		if err != nil {
+			notifyAll(s.notifiers, func(n Notifier) { n.OnAuthFailure(remote, err) })
 			return fmt.Errorf("auth handshake: %w", err)
 		}
+		principal, token, resumedFrom = p, issued, from
+		authUsername := ""
+		if principal != nil {
+			authUsername = principal.Username
+		}
+		notifyAll(s.notifiers, func(n Notifier) { n.OnAuthSuccess(remote, authUsername) })
+	}
+	// The per-user limit can only be enforced once the handshake has
+	// resolved a Principal, i.e. after the client already saw "ok"; closing
+	// here still stops it from reaching the backend, just a beat later
+	// than the server-wide maxConn check in registerConn.
+	if principal != nil {
+		if !s.registerUserConn(principal.Username, principal.MaxConnections) {
+			return fmt.Errorf("user %q exceeded max_connections", principal.Username)
+		}
+		defer s.unregisterUserConn(principal.Username)
 	}
 
-	s.logger.WithField("ftp", s.cfg.Server.FTPServerAddr).Debug("acquiring ftp connection")
-	acquireCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.Timeout)
-	defer cancel()
-	ftpConn, err := s.pool.Acquire(acquireCtx)
-	if err != nil {
-		return fmt.Errorf("dial ftp server: %w", err)
+	var ftpConn net.Conn
+	var sentBuf, recvBuf *ringBuffer
+	if resumedFrom != "" {
+		if pending, ok := s.takeResumableWait(resumedFrom, resumeStashWait); ok {
+			ftpConn, sentBuf, recvBuf = pending.ftpConn, pending.sentBuf, pending.recvBuf
+			s.logger.WithField("remote", tunnelConn.RemoteAddr().String()).Debug("reattaching resumed ftp connection")
+			if err := s.replayAfterResume(tunnelConn, sentBuf, recvBuf, true); err != nil {
+				s.pool.Release(ftpConn, false)
+				return fmt.Errorf("resume replay: %w", err)
+			}
+		} else if err := s.replayAfterResume(tunnelConn, nil, nil, false); err != nil {
+			return fmt.Errorf("resume replay: %w", err)
+		}
 	}
-	s.logger.WithField("ftp", s.cfg.Server.FTPServerAddr).Debug("ftp connection acquired")
+
 	if ftpConn == nil {
-		return fmt.Errorf("dial ftp server: nil connection")
+		s.logger.WithField("ftp", s.cfg.Server.FTPServerAddr).Debug("acquiring ftp connection")
+		acquireCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.Timeout)
+		defer cancel()
+		var err error
+		ftpConn, err = s.pool.Acquire(acquireCtx)
+		if err != nil {
+			return fmt.Errorf("dial ftp server: %w", err)
+		}
+		s.logger.WithField("ftp", s.cfg.Server.FTPServerAddr).Debug("ftp connection acquired")
+		sentBuf, recvBuf = newRingBuffer(), newRingBuffer()
 	}
 
-	s.logger.WithFields(logrus.Fields{
-		"remote": tunnelConn.RemoteAddr().String(),
-		"ftp":    s.cfg.Server.FTPServerAddr,
-	}).Info("proxy connection established")
+	s.logger.WithFields(s.connFields(tunnelConn, principal)).Info("proxy connection established")
 
-	copyErr := proxyWithIdle(tunnelConn, ftpConn, s.cfg.Server.IdleTimeout)
+	start := time.Now()
+	sentBefore, recvBefore := sentBuf.End(), recvBuf.End()
+	copyErr := proxyWithIdleResumable(tunnelConn, ftpConn, s.idleTimeout(principal), sentBuf, recvBuf)
+	username, duration := "", time.Since(start)
+	bytesOut, bytesIn := sentBuf.End()-sentBefore, recvBuf.End()-recvBefore
+	if principal != nil {
+		username = principal.Username
+	}
+	notifyAll(s.notifiers, func(n Notifier) { n.OnDisconnect(remote, username, bytesIn, bytesOut, duration) })
+	// Single-stream mode proxies raw bytes once the handshake completes, so
+	// there's no MsgClose frame to distinguish a deliberate disconnect from
+	// a dropped connection the way handleMultiplexedConn can (io.Copy
+	// returns nil for a plain peer FIN same as any other tear-down). Stash
+	// unconditionally and let resumeGrace release it if the client never
+	// comes back.
+	if token != "" {
+		s.tokenStore.SetOffsets(token, map[uint32]uint64{protocol.ControlStream: sentBuf.End()})
+		s.stashResumable(token, ftpConn, sentBuf, recvBuf)
+		return copyErr
+	}
 	s.pool.Release(ftpConn, copyErr == nil)
 	return copyErr
 }
 
+// replayAfterResume completes the byte-accounting half of a session resume.
+// It reads the client's MsgAck reporting how much it already received and
+// replays anything beyond that from sentBuf (if resumed, i.e. sentBuf holds
+// real history), then reports back how much client-sent data this server
+// already forwarded so the client can replay its own gap.
+func (s *Server) replayAfterResume(conn net.Conn, sentBuf, recvBuf *ringBuffer, resumed bool) error {
+	if s.cfg.Server.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.cfg.Server.Timeout))
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	msg, err := protocol.Decode(conn)
+	if err != nil {
+		return fmt.Errorf("read resume ack: %w", err)
+	}
+	if msg.Type != protocol.MsgAck {
+		return fmt.Errorf("expected ack, got message type %d", msg.Type)
+	}
+
+	if resumed {
+		if clientOffset, _, ok := decodeAck(msg.Payload); ok {
+			if replay, ok := sentBuf.Since(clientOffset); ok && len(replay) > 0 {
+				if _, err := conn.Write(replay); err != nil {
+					return fmt.Errorf("replay to client: %w", err)
+				}
+			}
+		}
+	}
+
+	var recvOffset uint64
+	if recvBuf != nil {
+		recvOffset = recvBuf.End()
+	}
+	frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAck, Payload: encodeAck(recvOffset, resumed)})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(frame)
+	return err
+}
+
+// handleMultiplexedConn wraps tunnelConn in a Session and serves one backend
+// FTP connection per stream the client opens, so a single authenticated
+// tunnel connection can carry many concurrent FTP sessions.
+func (s *Server) handleMultiplexedConn(ctx context.Context, tunnelConn net.Conn) error {
+	remote := tunnelConn.RemoteAddr().String()
+	var token string
+	var principal *auth.Principal
+	if s.cfg.Auth.Enabled {
+		p, issued, _, err := auth.HandshakeServer(tunnelConn, s.authenticator, s.tokenStore, s.cfg.Server.Timeout)
+		if err != nil {
+			notifyAll(s.notifiers, func(n Notifier) { n.OnAuthFailure(remote, err) })
+			return fmt.Errorf("auth handshake: %w", err)
+		}
+		principal, token = p, issued
+		authUsername := ""
+		if principal != nil {
+			authUsername = principal.Username
+		}
+		notifyAll(s.notifiers, func(n Notifier) { n.OnAuthSuccess(remote, authUsername) })
+	}
+	if principal != nil {
+		if !s.registerUserConn(principal.Username, principal.MaxConnections) {
+			return fmt.Errorf("user %q exceeded max_connections", principal.Username)
+		}
+		defer s.unregisterUserConn(principal.Username)
+	}
+	s.logger.WithFields(s.connFields(tunnelConn, principal)).Debug("multiplexed tunnel connection established")
+	start := time.Now()
+
+	sess := NewSession(tunnelConn, s.idleTimeout(principal), 1, func(stream *Stream) {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			// A non-empty target means the client opened this as a
+			// passive-mode (PASV/EPSV) data channel, not a new FTP
+			// session; see ftp.go.
+			if stream.Target() != "" {
+				acceptDataStream(s.logger, s.cfg.Server.Timeout, stream)
+				return
+			}
+			serve := s.serveStream
+			if s.cfg.Server.FTPMode {
+				serve = s.serveStreamFTP
+			}
+			if err := serve(ctx, stream); err != nil {
+				s.logger.WithError(err).WithField("stream", stream.ID()).Debug("stream closed with error")
+			}
+		}()
+	})
+	go func() {
+		<-ctx.Done()
+		_ = sess.Close(ctx.Err())
+	}()
+
+	sessErr := sess.Wait()
+	// sess.Wait returns nil only when the peer sent a control-stream
+	// MsgClose (see Session.CloseGracefully), i.e. a deliberate shutdown;
+	// anything else (including ctx cancellation above) is treated as a
+	// drop that should leave the token resumable.
+	if token != "" && sessErr == nil {
+		s.tokenStore.Delete(token)
+	}
+	username := ""
+	if principal != nil {
+		username = principal.Username
+	}
+	// Bytes aren't tracked per tunnel connection in multiplexed mode (each
+	// stream proxies independently; see serveStream/serveStreamFTP), so
+	// this reports 0/0 rather than a misleading partial count.
+	notifyAll(s.notifiers, func(n Notifier) { n.OnDisconnect(remote, username, 0, 0, time.Since(start)) })
+	return sessErr
+}
+
+// serveStream acquires a backend FTP connection for a single multiplexed
+// stream and copies bytes between them until either side is done.
+func (s *Server) serveStream(ctx context.Context, stream *Stream) error {
+	acquireCtx, cancel := context.WithTimeout(ctx, s.cfg.Server.Timeout)
+	defer cancel()
+	ftpConn, err := s.pool.Acquire(acquireCtx)
+	if err != nil {
+		_ = stream.Close()
+		return fmt.Errorf("dial ftp server: %w", err)
+	}
+
+	err = proxyStream(ftpConn, stream)
+	s.pool.Release(ftpConn, err == nil)
+	return err
+}
+
+// serveStreamFTP is serveStream for an FTP-aware control connection: it
+// acquires a backend connection exactly the same way, but splices
+// PORT/EPRT rewriting into the client->backend direction so an active-mode
+// data channel reaches the real backend server via a shadow listener
+// instead of the address the real FTP client originally sent, which the
+// backend usually can't reach directly; see ftp.go.
+func (s *Server) serveStreamFTP(ctx context.Context, stream *Stream) error {
+	acquireCtx, cancel := context.WithTimeout(ctx, s.cfg.Server.Timeout)
+	defer cancel()
+	ftpConn, err := s.pool.Acquire(acquireCtx)
+	if err != nil {
+		_ = stream.Close()
+		return fmt.Errorf("dial ftp server: %w", err)
+	}
+
+	err = proxyFTPControlServer(s.logger, stream, ftpConn)
+	s.pool.Release(ftpConn, err == nil)
+	return err
+}
+
 func proxy(a, b net.Conn) error {
 	errs := make(chan error, 2)
 	// Copy in both directions and close the opposite side when done.
@@ -133,6 +440,32 @@ func proxy(a, b net.Conn) error {
 	return firstErr
 }
 
+// connFields builds the logging fields for a newly established proxy
+// connection, adding the authenticated username and roles when a Principal
+// was resolved during the handshake.
+func (s *Server) connFields(tunnelConn net.Conn, principal *auth.Principal) logrus.Fields {
+	fields := logrus.Fields{
+		"remote": tunnelConn.RemoteAddr().String(),
+		"ftp":    s.cfg.Server.FTPServerAddr,
+	}
+	if principal != nil {
+		fields["user"] = principal.Username
+		if len(principal.Roles) > 0 {
+			fields["roles"] = principal.Roles
+		}
+	}
+	return fields
+}
+
+// idleTimeout resolves the idle timeout for a connection, preferring the
+// Principal's per-user override (if any) over the server-wide default.
+func (s *Server) idleTimeout(principal *auth.Principal) time.Duration {
+	if principal != nil && principal.IdleTimeout > 0 {
+		return principal.IdleTimeout
+	}
+	return s.cfg.Server.IdleTimeout
+}
+
 func (s *Server) registerConn(c net.Conn) bool {
 	s.connMu.Lock()
 	defer s.connMu.Unlock()
@@ -144,6 +477,34 @@ func (s *Server) registerConn(c net.Conn) bool {
 	return true
 }
 
+// registerUserConn enforces a Principal's MaxConnections, independent of
+// the server-wide maxConn cap. A zero username or limit means "no per-user
+// cap configured", so it always succeeds.
+func (s *Server) registerUserConn(username string, limit int) bool {
+	if username == "" || limit <= 0 {
+		return true
+	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.userConns[username] >= limit {
+		return false
+	}
+	s.userConns[username]++
+	return true
+}
+
+// unregisterUserConn releases a slot claimed by registerUserConn.
+func (s *Server) unregisterUserConn(username string) {
+	if username == "" {
+		return
+	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.userConns[username] > 0 {
+		s.userConns[username]--
+	}
+}
+
 func (s *Server) unregisterConn(c net.Conn) {
 	s.connMu.Lock()
 	defer s.connMu.Unlock()
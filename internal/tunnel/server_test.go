@@ -2,12 +2,14 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/njangra/falcon-tunnel/internal/auth"
 	"github.com/njangra/falcon-tunnel/internal/config"
+	"github.com/njangra/falcon-tunnel/pkg/protocol"
 	"github.com/sirupsen/logrus"
 )
 
@@ -61,7 +63,7 @@ func TestServerProxiesData(t *testing.T) {
 	}
 	defer clientConn.Close()
 
-	if err := auth.HandshakeClient(clientConn, "secret", cfg.Server.Timeout); err != nil {
+	if _, err := auth.HandshakeClient(clientConn, "ignored-user", "secret", cfg.Server.Timeout); err != nil {
 		t.Fatalf("handshake client: %v", err)
 	}
 
@@ -81,6 +83,220 @@ func TestServerProxiesData(t *testing.T) {
 	cancel()
 }
 
+func TestServerResumeWithTokenSkipsPassword(t *testing.T) {
+	ftpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ftp listen: %v", err)
+	}
+	defer ftpLn.Close()
+	go acceptAndEcho(ftpLn)
+
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	cfg := config.Config{
+		Server: config.ServerConfig{
+			ListenAddr:     "127.0.0.1:0",
+			FTPServerAddr:  ftpLn.Addr().String(),
+			MaxConnections: 10,
+			Timeout:        2 * time.Second,
+		},
+		Auth: config.AuthConfig{
+			Enabled:      true,
+			PasswordHash: hash,
+		},
+		Log: config.LogConfig{
+			Level:  "error",
+			Format: "text",
+		},
+	}
+
+	ln, err := net.Listen("tcp", cfg.Server.ListenAddr)
+	if err != nil {
+		t.Fatalf("server listen: %v", err)
+	}
+	defer ln.Close()
+
+	lg := logrus.New()
+	lg.SetLevel(logrus.DebugLevel)
+	srv := NewServer(cfg, nil, lg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Serve(ctx, ln)
+	}()
+
+	firstConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	token, err := auth.HandshakeClient(firstConn, "ignored-user", "secret", cfg.Server.Timeout)
+	if err != nil {
+		t.Fatalf("handshake client: %v", err)
+	}
+	if token == "" {
+		t.Fatalf("expected server to issue a resumption token")
+	}
+	firstConn.Close()
+
+	secondConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("client reconnect: %v", err)
+	}
+	defer secondConn.Close()
+	if _, err := auth.ResumeClient(secondConn, token, cfg.Server.Timeout); err != nil {
+		t.Fatalf("resume client: %v", err)
+	}
+
+	// Complete the resume's byte-accounting handshake, reporting that this
+	// client received nothing before the drop, then discard the server's
+	// reply (there's nothing to replay in this test).
+	ackFrame, err := protocol.Encode(protocol.Message{Type: protocol.MsgAck, Payload: encodeAck(0, true)})
+	if err != nil {
+		t.Fatalf("encode resume ack: %v", err)
+	}
+	if _, err := secondConn.Write(ackFrame); err != nil {
+		t.Fatalf("write resume ack: %v", err)
+	}
+	if _, err := protocol.Decode(secondConn); err != nil {
+		t.Fatalf("read resume ack: %v", err)
+	}
+
+	payload := []byte("ping after resume")
+	if _, err := secondConn.Write(payload); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	buf := make([]byte, len(payload))
+	if _, err := secondConn.Read(buf); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if string(buf) != string(payload) {
+		t.Fatalf("expected echo %q got %q", payload, buf)
+	}
+}
+
+func TestServerEnforcesPerUserMaxConnections(t *testing.T) {
+	ftpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ftp listen: %v", err)
+	}
+	defer ftpLn.Close()
+	go acceptAndEcho(ftpLn)
+
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	cfg := config.Config{
+		Server: config.ServerConfig{
+			ListenAddr:     "127.0.0.1:0",
+			FTPServerAddr:  ftpLn.Addr().String(),
+			MaxConnections: 10,
+			Timeout:        2 * time.Second,
+		},
+		Auth: config.AuthConfig{
+			Enabled: true,
+			Users: []config.UserConfig{
+				{Username: "alice", PasswordHash: hash, MaxConnections: 1},
+			},
+		},
+		Log: config.LogConfig{
+			Level:  "error",
+			Format: "text",
+		},
+	}
+
+	ln, err := net.Listen("tcp", cfg.Server.ListenAddr)
+	if err != nil {
+		t.Fatalf("server listen: %v", err)
+	}
+	defer ln.Close()
+
+	srv := NewServer(cfg, nil, logrus.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Serve(ctx, ln)
+	}()
+
+	firstConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer firstConn.Close()
+	if _, err := auth.HandshakeClient(firstConn, "alice", "secret", cfg.Server.Timeout); err != nil {
+		t.Fatalf("first handshake: %v", err)
+	}
+
+	secondConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer secondConn.Close()
+	if _, err := auth.HandshakeClient(secondConn, "alice", "secret", cfg.Server.Timeout); err != nil {
+		t.Fatalf("second handshake: %v", err)
+	}
+
+	// The second connection's handshake succeeds (the limit is enforced
+	// after auth, see Server.handleConn), but the server then closes it
+	// immediately rather than proxying, so the connection reads EOF.
+	buf := make([]byte, 1)
+	secondConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := secondConn.Read(buf); err == nil {
+		t.Fatalf("expected the over-limit connection to be closed")
+	}
+}
+
+// TestVerifyPeerAddressBoundedByTimeout exercises TLS.VerifyPeerAddress
+// against a peer that opens the connection but never drives the TLS
+// handshake (a slow-loris client). verifyPeerAddress must give up once
+// cfg.Server.Timeout elapses rather than blocking the goroutine forever.
+func TestVerifyPeerAddressBoundedByTimeout(t *testing.T) {
+	cert, key, err := config.GenerateSelfSigned("127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("self-signed cert: %v", err)
+	}
+	dir := t.TempDir()
+	certPath := writeTempFile(t, dir, "cert.pem", cert)
+	keyPath := writeTempFile(t, dir, "key.pem", key)
+
+	serverTLS, err := config.ServerTLSConfig(config.TLSConfig{
+		Enabled:  true,
+		CertFile: certPath,
+		KeyFile:  keyPath,
+	})
+	if err != nil {
+		t.Fatalf("server tls config: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	srv := NewServer(config.Config{
+		Server: config.ServerConfig{Timeout: 100 * time.Millisecond},
+		TLS:    config.TLSConfig{VerifyPeerAddress: true},
+		Log:    config.LogConfig{Level: "error", Format: "text"},
+	}, nil, logrus.New())
+
+	tlsConn := tls.Server(serverConn, serverTLS)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.verifyPeerAddress(tlsConn)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected a handshake timeout error, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("verifyPeerAddress did not return within its configured timeout")
+	}
+}
+
 func acceptAndEcho(ln net.Listener) {
 	for {
 		conn, err := ln.Accept()
@@ -0,0 +1,327 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/njangra/falcon-tunnel/pkg/protocol"
+)
+
+// ErrSessionClosed is returned by Session operations attempted after Close.
+var ErrSessionClosed = errors.New("tunnel: session closed")
+
+// Session multiplexes many logical FTP streams over a single underlying
+// tunnel connection. One reader goroutine demultiplexes incoming frames into
+// per-stream io.Pipe pairs; one writer goroutine serializes outgoing frames
+// from a shared channel, so callers never write to conn directly. A
+// heartbeat is sent every idleTimeout/3 and resets a peer-liveness deadline,
+// replacing the per-byte SetDeadline refresh used by the single-stream path.
+type Session struct {
+	conn        net.Conn
+	idleTimeout time.Duration
+
+	// onOpen is invoked whenever the peer opens a new stream (on the client,
+	// only FTP-mode data streams opened by the server).
+	// It must not block for long; long-lived work should run in its own goroutine.
+	onOpen func(s *Stream)
+
+	writeCh   chan protocol.Message
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32 // client-assigned stream ids increment by 2 to avoid colliding with the peer's
+
+	lastRecv atomic.Int64 // unix nano
+
+	wg sync.WaitGroup
+}
+
+// NewSession wraps conn with the multiplexing frame layer. idOffset should be
+// 0 for the client's session and 1 for the server's (which opens streams
+// only for FTP-mode data channels), so ids assigned independently by each
+// side never collide. protocol.ControlStream (0) is reserved for
+// session-level control messages (see Close's handling of MsgClose), so an
+// idOffset of 0 is bumped to 2 before it is ever used as a real stream id.
+func NewSession(conn net.Conn, idleTimeout time.Duration, idOffset uint32, onOpen func(s *Stream)) *Session {
+	if idOffset == protocol.ControlStream {
+		idOffset = protocol.ControlStream + 2
+	}
+	s := &Session{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		onOpen:      onOpen,
+		writeCh:     make(chan protocol.Message, 64),
+		closeCh:     make(chan struct{}),
+		streams:     make(map[uint32]*Stream),
+		nextID:      idOffset,
+	}
+	s.lastRecv.Store(time.Now().UnixNano())
+
+	s.wg.Add(2)
+	go s.writeLoop()
+	go s.readLoop()
+	if idleTimeout > 0 {
+		s.wg.Add(1)
+		go s.heartbeatLoop()
+	}
+	return s
+}
+
+// OpenStream allocates a new stream id, tells the peer about it, and returns
+// a Stream the caller can read from and write to like a connection.
+func (s *Session) OpenStream() (*Stream, error) {
+	return s.openStream(protocol.MsgOpen, "")
+}
+
+// OpenDataStream is OpenStream for an FTP data channel: target is the
+// "host:port" of the real data peer (an FTP server's PASV/EPSV address, or
+// an FTP client's PORT/EPRT address) that the *receiving* side should dial
+// once the stream is accepted. The local side is expected to bridge the
+// stream to whatever local connection already arrived on its shadow
+// listener for this data channel; see the FTP active/passive handling in
+// ftp.go.
+func (s *Session) OpenDataStream(target string) (*Stream, error) {
+	return s.openStream(protocol.MsgOpenData, target)
+}
+
+func (s *Session) openStream(msgType protocol.MessageType, target string) (*Stream, error) {
+	s.mu.Lock()
+	if s.streams == nil {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(id, s)
+	st.target = target
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.send(protocol.Message{Type: msgType, StreamID: id, Payload: []byte(target)}); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *Session) send(msg protocol.Message) error {
+	select {
+	case s.writeCh <- msg:
+		return nil
+	case <-s.closeCh:
+		return ErrSessionClosed
+	}
+}
+
+func (s *Session) writeLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case msg := <-s.writeCh:
+			frame, err := protocol.Encode(msg)
+			if err != nil {
+				continue
+			}
+			if _, err := s.conn.Write(frame); err != nil {
+				s.Close(err)
+				return
+			}
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+func (s *Session) readLoop() {
+	defer s.wg.Done()
+	for {
+		msg, err := protocol.Decode(s.conn)
+		if err != nil {
+			s.Close(err)
+			return
+		}
+		s.lastRecv.Store(time.Now().UnixNano())
+
+		switch msg.Type {
+		case protocol.MsgHeartbeat:
+			// liveness only; nothing further to do.
+		case protocol.MsgOpen:
+			s.acceptStream(msg.StreamID, "")
+		case protocol.MsgOpenData:
+			s.acceptStream(msg.StreamID, string(msg.Payload))
+		case protocol.MsgData:
+			s.dispatchData(msg.StreamID, msg.Payload)
+		case protocol.MsgClose:
+			if msg.StreamID == protocol.ControlStream {
+				// The peer is shutting down the whole session cleanly,
+				// as opposed to dropping it; Close(nil) lets callers
+				// tell the two apart via Wait's return value.
+				s.Close(nil)
+				return
+			}
+			s.closeStream(msg.StreamID, false)
+		}
+	}
+}
+
+func (s *Session) acceptStream(id uint32, target string) {
+	s.mu.Lock()
+	if s.streams == nil {
+		s.mu.Unlock()
+		return
+	}
+	st := newStream(id, s)
+	st.target = target
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if s.onOpen != nil {
+		s.onOpen(st)
+	}
+}
+
+func (s *Session) dispatchData(id uint32, payload []byte) {
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		return
+	}
+	_, _ = st.pw.Write(payload)
+}
+
+func (s *Session) closeStream(id uint32, notifyPeer bool) {
+	s.mu.Lock()
+	st := s.streams[id]
+	delete(s.streams, id)
+	s.mu.Unlock()
+	if st == nil {
+		return
+	}
+	_ = st.pw.Close()
+	if notifyPeer {
+		_ = s.send(protocol.Message{Type: protocol.MsgClose, StreamID: id})
+	}
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) heartbeatLoop() {
+	defer s.wg.Done()
+	interval := s.idleTimeout / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if time.Since(time.Unix(0, s.lastRecv.Load())) > s.idleTimeout {
+				s.Close(fmt.Errorf("tunnel: peer heartbeat timeout after %s", s.idleTimeout))
+				return
+			}
+			_ = s.send(protocol.Message{Type: protocol.MsgHeartbeat})
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// Wait blocks until the session is closed (by either peer or by Close) and
+// returns the error that caused the closure, if any.
+func (s *Session) Wait() error {
+	<-s.closeCh
+	return s.closeErr
+}
+
+// CloseGracefully tells the peer the session is ending intentionally (rather
+// than dropping) by sending a control-stream MsgClose before tearing down,
+// so the peer can tell a deliberate shutdown apart from a transient drop.
+// The frame is written directly rather than via send/writeCh, since Close
+// immediately after would otherwise race writeLoop for whether the frame
+// actually reaches the wire before the conn is torn down.
+func (s *Session) CloseGracefully() error {
+	if frame, err := protocol.Encode(protocol.Message{Type: protocol.MsgClose, StreamID: protocol.ControlStream}); err == nil {
+		_, _ = s.conn.Write(frame)
+	}
+	return s.Close(nil)
+}
+
+// Close tears down the session, closing all open streams and the underlying
+// connection. Safe to call multiple times.
+func (s *Session) Close(cause error) error {
+	s.closeOnce.Do(func() {
+		s.closeErr = cause
+		close(s.closeCh)
+		_ = s.conn.Close()
+
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = nil
+		s.mu.Unlock()
+		for _, st := range streams {
+			_ = st.pw.Close()
+		}
+	})
+	return s.closeErr
+}
+
+// Stream is a single multiplexed logical connection within a Session. It
+// implements io.ReadWriteCloser; Read drains frames the session's reader
+// goroutine has demultiplexed into this stream's pipe.
+type Stream struct {
+	id      uint32
+	session *Session
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+
+	// target is the "host:port" of the real data peer this stream was
+	// opened for via OpenDataStream/MsgOpenData, and is empty for an
+	// ordinary FTP-session stream opened via OpenStream/MsgOpen.
+	target string
+}
+
+func newStream(id uint32, session *Session) *Stream {
+	pr, pw := io.Pipe()
+	return &Stream{id: id, session: session, pr: pr, pw: pw}
+}
+
+// ID returns the stream's identifier, unique within its Session.
+func (st *Stream) ID() uint32 { return st.id }
+
+// Target returns the "host:port" this stream was opened for via
+// OpenDataStream, or "" for a stream opened via OpenStream.
+func (st *Stream) Target() string { return st.target }
+
+func (st *Stream) Read(p []byte) (int, error) {
+	return st.pr.Read(p)
+}
+
+func (st *Stream) Write(p []byte) (int, error) {
+	// io.Writer implementations must not retain p; copy it before handing it
+	// off to writeLoop, which encodes it asynchronously after Write returns.
+	payload := append([]byte(nil), p...)
+	if err := st.session.send(protocol.Message{Type: protocol.MsgData, StreamID: st.id, Payload: payload}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close tells the peer this stream is done and releases local resources.
+func (st *Stream) Close() error {
+	st.session.closeStream(st.id, true)
+	return nil
+}
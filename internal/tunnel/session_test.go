@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionOpenStreamEchoesData(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	var accepted *Stream
+	done := make(chan struct{})
+	server := NewSession(serverConn, 0, 1, func(st *Stream) {
+		accepted = st
+		close(done)
+		go io.Copy(st, st)
+	})
+	defer server.Close(nil)
+
+	client := NewSession(clientConn, 0, 0, nil)
+	defer client.Close(nil)
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer stream.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for server to accept stream")
+	}
+	if accepted.ID() != stream.ID() {
+		t.Fatalf("expected matching stream ids, got client=%d server=%d", stream.ID(), accepted.ID())
+	}
+
+	if _, err := stream.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("expected echoed payload, got %q", buf)
+	}
+}
+
+func TestSessionMultiplexesConcurrentStreams(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	server := NewSession(serverConn, 0, 1, func(st *Stream) {
+		go io.Copy(st, st)
+	})
+	defer server.Close(nil)
+
+	client := NewSession(clientConn, 0, 0, nil)
+	defer client.Close(nil)
+
+	const streams = 4
+	for i := 0; i < streams; i++ {
+		stream, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("open stream %d: %v", i, err)
+		}
+		payload := []byte{byte('a' + i)}
+		if _, err := stream.Write(payload); err != nil {
+			t.Fatalf("write stream %d: %v", i, err)
+		}
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			t.Fatalf("read stream %d: %v", i, err)
+		}
+		if buf[0] != payload[0] {
+			t.Fatalf("stream %d: expected %q got %q", i, payload, buf)
+		}
+		_ = stream.Close()
+	}
+}
+
+func TestSessionHeartbeatTimeoutClosesSession(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	// No peer on the other end actively reading frames, so the client's
+	// own heartbeats never update the server's lastRecv; use a very short
+	// idle timeout so the server gives up quickly instead of hanging the
+	// test.
+	server := NewSession(serverConn, 20*time.Millisecond, 1, nil)
+
+	select {
+	case <-server.closeCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected session to close after heartbeat timeout")
+	}
+	if server.Wait() == nil {
+		t.Fatal("expected a non-nil close error after heartbeat timeout")
+	}
+}
@@ -17,22 +17,52 @@ const (
 	MsgData
 	MsgClose
 	MsgHeartbeat
+	MsgOpen
+	MsgResume
+	MsgAck
+	MsgOpenData
 )
 
+// Version identifies the wire format understood by this package. The auth
+// package prepends it as the first byte of every MsgAuth/MsgResume payload
+// so a peer running an incompatible frame layout is rejected during the
+// handshake instead of producing garbled stream data later.
+const Version byte = 2
+
+// ControlStream is the reserved stream ID for messages that aren't tied to a
+// specific multiplexed FTP session (auth, heartbeats).
+const ControlStream uint32 = 0
+
 // Message is the base frame type for tunnel communication.
 // Payload semantics:
-// - MsgAuth: plaintext password or token (later)
-// - MsgAuthResponse: "ok" or error string
-// - MsgData: raw FTP payload
-// - MsgClose: optional reason text
-// - MsgHeartbeat: empty payload
+//   - MsgAuth: [2 byte username length][username][password]
+//   - MsgAuthResponse: "ok\x00<hex-token>" on success (token omitted if
+//     session resumption isn't configured), or an error string
+//   - MsgResume: a previously issued hex session token, presented in place
+//     of MsgAuth to reauthenticate without a password after a reconnect
+//   - MsgOpen: request to open StreamID as a new multiplexed FTP session
+//   - MsgData: raw FTP payload for StreamID
+//   - MsgClose: optional reason text for StreamID; StreamID ControlStream
+//     means the whole session is ending, not just one multiplexed stream
+//   - MsgHeartbeat: empty payload, StreamID is ControlStream
+//   - MsgAck: exchanged once immediately after a successful session resume,
+//     StreamID is ControlStream, payload is [1 byte resumed flag][8 byte
+//     big-endian byte offset] reporting how much data the sender already
+//     has, so the peer can replay anything beyond that from its own
+//     retained buffer before ordinary proxying resumes
+//   - MsgOpenData: like MsgOpen, but StreamID names an FTP data channel
+//     rather than a whole FTP session; payload is the "host:port" the
+//     receiving side should dial to reach the real data peer (the FTP
+//     server's PASV/EPSV address, or the FTP client's PORT/EPRT address),
+//     see the tunnel package's active/passive data channel handling
 type Message struct {
-	Type    MessageType
-	Payload []byte
+	Type     MessageType
+	StreamID uint32
+	Payload  []byte
 }
 
 const (
-	headerSize   = 5 // 1 byte type + 4 byte payload length
+	headerSize   = 9 // 1 byte type + 4 byte stream id + 4 byte payload length
 	maxPayload   = 1 << 20
 	minFrameSize = headerSize
 )
@@ -44,13 +74,17 @@ var (
 	ErrIncompleteFrame = errors.New("protocol: incomplete frame")
 )
 
-// Encode encodes a Message into a length-prefixed frame: [1 byte type][4 byte payload length][payload].
+// Encode encodes a Message into a length-prefixed frame:
+// [1 byte type][4 byte stream id][4 byte payload length][payload].
 func Encode(msg Message) ([]byte, error) {
 	if len(msg.Payload) > maxPayload {
 		return nil, ErrFrameTooLarge
 	}
 	buf := bytes.NewBuffer(make([]byte, 0, headerSize+len(msg.Payload)))
 	buf.WriteByte(byte(msg.Type))
+	if err := binary.Write(buf, binary.BigEndian, msg.StreamID); err != nil {
+		return nil, err
+	}
 	if err := binary.Write(buf, binary.BigEndian, uint32(len(msg.Payload))); err != nil {
 		return nil, err
 	}
@@ -69,7 +103,8 @@ func Decode(r io.Reader) (*Message, error) {
 		return nil, err
 	}
 	msgType := MessageType(header[0])
-	length := binary.BigEndian.Uint32(header[1:])
+	streamID := binary.BigEndian.Uint32(header[1:5])
+	length := binary.BigEndian.Uint32(header[5:9])
 	if length > maxPayload {
 		return nil, ErrFrameTooLarge
 	}
@@ -82,7 +117,7 @@ func Decode(r io.Reader) (*Message, error) {
 			return nil, err
 		}
 	}
-	return &Message{Type: msgType, Payload: payload}, nil
+	return &Message{Type: msgType, StreamID: streamID, Payload: payload}, nil
 }
 
 // MustEncode wraps Encode and panics on error; useful for static messages in tests.
@@ -7,7 +7,7 @@ import (
 )
 
 func TestEncodeDecodeRoundTrip(t *testing.T) {
-	msg := Message{Type: MsgData, Payload: []byte("hello")}
+	msg := Message{Type: MsgData, StreamID: 7, Payload: []byte("hello")}
 	frame, err := Encode(msg)
 	if err != nil {
 		t.Fatalf("encode: %v", err)
@@ -19,13 +19,16 @@ func TestEncodeDecodeRoundTrip(t *testing.T) {
 	if decoded.Type != msg.Type {
 		t.Fatalf("expected type %v got %v", msg.Type, decoded.Type)
 	}
+	if decoded.StreamID != msg.StreamID {
+		t.Fatalf("expected stream id %v got %v", msg.StreamID, decoded.StreamID)
+	}
 	if string(decoded.Payload) != "hello" {
 		t.Fatalf("payload mismatch")
 	}
 }
 
 func TestDecodeIncompleteFrame(t *testing.T) {
-	frame := []byte{byte(MsgData), 0, 0, 0, 5, 'h', 'i'}
+	frame := []byte{byte(MsgData), 0, 0, 0, 0, 0, 0, 0, 5, 'h', 'i'}
 	_, err := Decode(bytes.NewReader(frame))
 	if !errors.Is(err, ErrIncompleteFrame) {
 		t.Fatalf("expected incomplete frame error, got %v", err)
@@ -39,7 +42,7 @@ func TestFrameTooLarge(t *testing.T) {
 		t.Fatalf("expected frame too large error")
 	}
 
-	header := []byte{byte(MsgData), 0x10, 0x00, 0x00, 0x01} // large length
+	header := []byte{byte(MsgData), 0, 0, 0, 0, 0x10, 0x00, 0x00, 0x01} // large length
 	_, err = Decode(bytes.NewReader(header))
 	if !errors.Is(err, ErrFrameTooLarge) {
 		t.Fatalf("expected frame too large on decode")